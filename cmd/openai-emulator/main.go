@@ -41,6 +41,11 @@ func main() {
 
 	log.Println("Shutting down server...")
 
+	// Flip readyz to 503 first so a load balancer stops sending new
+	// traffic here before outstanding requests get their grace window
+	// below to finish.
+	srv.SetDraining(true)
+
 	// Give outstanding requests 5 seconds to complete
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
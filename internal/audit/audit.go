@@ -0,0 +1,113 @@
+// Package audit records every request the emulator handles so a user can
+// see exactly what their client sent and what was returned, export it as
+// HAR, or convert it back into a replayable Script.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Entry is one audited request/response pair.
+type Entry struct {
+	ID        string          `json:"id"`
+	Time      time.Time       `json:"time"`
+	Method    string          `json:"method"`
+	Path      string          `json:"path"`
+	TokenHash string          `json:"token_hash"`
+	Request   json.RawMessage `json:"request,omitempty"`
+	// MatchedRuleIndex is the index into the session's rule list that
+	// answered this request, or -1 if the response didn't come from rule
+	// matching (e.g. a recorded proxy turn, or a request that errored
+	// before matching ran).
+	MatchedRuleIndex int             `json:"matched_rule_index"`
+	Status           int             `json:"status"`
+	Response         json.RawMessage `json:"response,omitempty"`
+	ResponseBytes    int             `json:"response_bytes"`
+	StreamChunks     int             `json:"stream_chunks,omitempty"`
+	Latency          time.Duration   `json:"latency_ns"`
+}
+
+// HashToken derives the TokenHash stored on an Entry, so the audit log
+// never holds a bearer token in the clear.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// defaultMaxEntries bounds the log's memory footprint the same way
+// session.Manager bounds its session count: once full, the oldest entry is
+// dropped to make room for the newest, rather than growing without limit.
+const defaultMaxEntries = 1000
+
+// Log is an in-memory, append-only ring buffer of audited requests.
+type Log struct {
+	mu      sync.Mutex
+	entries []Entry
+	max     int
+	nextID  int
+}
+
+// NewLog creates an empty Log capped at defaultMaxEntries.
+func NewLog() *Log {
+	return &Log{max: defaultMaxEntries}
+}
+
+// Record appends entry to the log, assigning it an ID, and evicts the
+// oldest entry if the log is at capacity. It returns the assigned ID.
+func (l *Log) Record(entry Entry) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.nextID++
+	entry.ID = strconv.Itoa(l.nextID)
+
+	l.entries = append(l.entries, entry)
+	if len(l.entries) > l.max {
+		l.entries = l.entries[len(l.entries)-l.max:]
+	}
+
+	return entry.ID
+}
+
+// Get returns the entry with the given ID, if it's still in the log (it
+// may have been evicted).
+func (l *Log) Get(id string) (Entry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, e := range l.entries {
+		if e.ID == id {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// All returns a snapshot of every entry currently in the log, oldest
+// first.
+func (l *Log) All() []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	out := make([]Entry, len(l.entries))
+	copy(out, l.entries)
+	return out
+}
+
+// WriteNDJSON writes every entry in the log to w as newline-delimited
+// JSON, oldest first.
+func (l *Log) WriteNDJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, e := range l.All() {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestLogRecordAssignsSequentialIDs verifies Record returns ascending IDs
+// and Get can look an entry back up by it.
+func TestLogRecordAssignsSequentialIDs(t *testing.T) {
+	log := NewLog()
+
+	id1 := log.Record(Entry{Path: "/v1/chat/completions", Status: 200})
+	id2 := log.Record(Entry{Path: "/v1/embeddings", Status: 200})
+
+	if id1 == id2 {
+		t.Fatalf("expected distinct IDs, got %q twice", id1)
+	}
+
+	got, ok := log.Get(id2)
+	if !ok || got.Path != "/v1/embeddings" {
+		t.Errorf("Get(%q) = %+v, %v; want the embeddings entry", id2, got, ok)
+	}
+}
+
+// TestLogEvictsOldestWhenFull verifies the ring buffer drops the oldest
+// entry rather than growing without bound.
+func TestLogEvictsOldestWhenFull(t *testing.T) {
+	log := &Log{max: 2}
+
+	firstID := log.Record(Entry{Path: "/first"})
+	log.Record(Entry{Path: "/second"})
+	log.Record(Entry{Path: "/third"})
+
+	if _, ok := log.Get(firstID); ok {
+		t.Error("expected the first entry to have been evicted")
+	}
+
+	all := log.All()
+	if len(all) != 2 || all[0].Path != "/second" || all[1].Path != "/third" {
+		t.Errorf("All() = %+v; want [/second /third]", all)
+	}
+}
+
+// TestLogWriteNDJSONOneLinePerEntry verifies WriteNDJSON emits exactly one
+// JSON object per recorded entry, oldest first.
+func TestLogWriteNDJSONOneLinePerEntry(t *testing.T) {
+	log := NewLog()
+	log.Record(Entry{Path: "/v1/chat/completions"})
+	log.Record(Entry{Path: "/v1/embeddings"})
+
+	var buf bytes.Buffer
+	if err := log.WriteNDJSON(&buf); err != nil {
+		t.Fatalf("WriteNDJSON: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2: %q", len(lines), buf.String())
+	}
+
+	var first Entry
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("unmarshal first line: %v", err)
+	}
+	if first.Path != "/v1/chat/completions" {
+		t.Errorf("first line path = %q, want /v1/chat/completions", first.Path)
+	}
+}
+
+// TestHashTokenStableAndNotThePlaintext verifies HashToken is
+// deterministic and never reproduces the raw token.
+func TestHashTokenStableAndNotThePlaintext(t *testing.T) {
+	hash := HashToken("sk-test-secret")
+
+	if hash != HashToken("sk-test-secret") {
+		t.Error("expected HashToken to be deterministic")
+	}
+	if strings.Contains(hash, "sk-test-secret") {
+		t.Error("expected hash to not contain the raw token")
+	}
+}
+
+// TestBuildHARIncludesEveryEntry verifies BuildHAR carries each Entry's
+// request/response data into the resulting HAR document.
+func TestBuildHARIncludesEveryEntry(t *testing.T) {
+	entries := []Entry{
+		{
+			Method:   "POST",
+			Path:     "/v1/chat/completions",
+			Request:  json.RawMessage(`{"model":"gpt-4"}`),
+			Status:   200,
+			Response: json.RawMessage(`{"id":"chatcmpl-1"}`),
+		},
+	}
+
+	har := BuildHAR(entries)
+
+	if len(har.Log.Entries) != 1 {
+		t.Fatalf("got %d HAR entries, want 1", len(har.Log.Entries))
+	}
+	got := har.Log.Entries[0]
+	if got.Request.Method != "POST" || got.Request.URL != "/v1/chat/completions" {
+		t.Errorf("request = %+v; want method POST, url /v1/chat/completions", got.Request)
+	}
+	if got.Response.Status != 200 || got.Response.Content.Text != `{"id":"chatcmpl-1"}` {
+		t.Errorf("response = %+v; want status 200 with the recorded body", got.Response)
+	}
+}
+
+// TestBuildHAROmitsPostDataForEmptyRequest verifies a GET-style entry with
+// no request body doesn't get a spurious empty PostData block.
+func TestBuildHAROmitsPostDataForEmptyRequest(t *testing.T) {
+	har := BuildHAR([]Entry{{Method: "GET", Path: "/v1/models"}})
+
+	if har.Log.Entries[0].Request.PostData != nil {
+		t.Error("expected PostData to be nil for a request with no body")
+	}
+}
+
+// TestToScriptSkipsMatchedEntries verifies only recorded-proxy turns
+// (MatchedRuleIndex == -1) are converted into rules.
+func TestToScriptSkipsMatchedEntries(t *testing.T) {
+	entries := []Entry{
+		{Method: "POST", Path: "/v1/chat/completions", MatchedRuleIndex: 0, Status: 200},
+		{Method: "POST", Path: "/v1/chat/completions", MatchedRuleIndex: -1, Status: 200,
+			Request: json.RawMessage(`{"model":"gpt-4"}`), Response: json.RawMessage(`{"id":"chatcmpl-2"}`)},
+	}
+
+	out := ToScript(entries)
+
+	if len(out.Rules) != 1 {
+		t.Fatalf("got %d rules, want 1", len(out.Rules))
+	}
+	rule := out.Rules[0]
+	if rule.Match.Method != "POST" || rule.Times != 1 {
+		t.Errorf("rule = %+v; want method POST, times 1", rule)
+	}
+	if string(rule.Response.JSON) != `{"id":"chatcmpl-2"}` {
+		t.Errorf("rule.Response.JSON = %s; want the recorded response", rule.Response.JSON)
+	}
+}
@@ -0,0 +1,100 @@
+package audit
+
+import "time"
+
+// HAR is a minimal subset of the HAR 1.2 (HTTP Archive) format
+// (http://www.softwareishard.com/blog/har-12-spec/) — just enough fields
+// for a captured request/response pair to open in any HAR viewer.
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HAREntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+}
+
+type HARRequest struct {
+	Method      string       `json:"method"`
+	URL         string       `json:"url"`
+	HTTPVersion string       `json:"httpVersion"`
+	Headers     []HARNVPair  `json:"headers"`
+	PostData    *HARPostData `json:"postData,omitempty"`
+}
+
+type HARResponse struct {
+	Status      int         `json:"status"`
+	HTTPVersion string      `json:"httpVersion"`
+	Headers     []HARNVPair `json:"headers"`
+	Content     HARContent  `json:"content"`
+}
+
+type HARNVPair struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type HARContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+// BuildHAR converts audited entries into a HAR log, in the order given.
+func BuildHAR(entries []Entry) HAR {
+	harEntries := make([]HAREntry, len(entries))
+	for i, e := range entries {
+		harEntries[i] = HAREntry{
+			StartedDateTime: e.Time.Format(time.RFC3339Nano),
+			Time:            float64(e.Latency) / float64(time.Millisecond),
+			Request: HARRequest{
+				Method:      e.Method,
+				URL:         e.Path,
+				HTTPVersion: "HTTP/1.1",
+				Headers:     []HARNVPair{{Name: "Authorization", Value: "Bearer " + e.TokenHash}},
+				PostData:    postData(e.Request),
+			},
+			Response: HARResponse{
+				Status:      e.Status,
+				HTTPVersion: "HTTP/1.1",
+				Headers:     []HARNVPair{{Name: "Content-Type", Value: "application/json"}},
+				Content: HARContent{
+					Size:     e.ResponseBytes,
+					MimeType: "application/json",
+					Text:     string(e.Response),
+				},
+			},
+		}
+	}
+
+	return HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "llemulator", Version: "1.0"},
+		Entries: harEntries,
+	}}
+}
+
+func postData(body []byte) *HARPostData {
+	if len(body) == 0 {
+		return nil
+	}
+	return &HARPostData{MimeType: "application/json", Text: string(body)}
+}
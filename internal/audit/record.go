@@ -0,0 +1,125 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Recorder proxies /v1/* traffic to a real upstream LLM API while
+// capturing each turn into a Log, so a session against the real thing can
+// later be converted into a replayable Script via ToScript.
+type Recorder struct {
+	mu       sync.Mutex
+	upstream *url.URL
+	active   bool
+	log      *Log
+}
+
+// NewRecorder creates a Recorder that writes captured turns into log.
+// upstreamBaseURL is normally read from an env var (e.g. UPSTREAM_BASE_URL)
+// by the caller; Recorder itself just proxies to whatever URL Start is
+// given.
+func NewRecorder(log *Log) *Recorder {
+	return &Recorder{log: log}
+}
+
+// Start begins proxying, directing requests at upstreamBaseURL. It errors
+// if upstreamBaseURL doesn't parse or recording is already active.
+func (r *Recorder) Start(upstreamBaseURL string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.active {
+		return fmt.Errorf("recording already active")
+	}
+	if upstreamBaseURL == "" {
+		return fmt.Errorf("no upstream configured")
+	}
+
+	upstream, err := url.Parse(upstreamBaseURL)
+	if err != nil {
+		return fmt.Errorf("invalid upstream URL: %w", err)
+	}
+
+	r.upstream = upstream
+	r.active = true
+	return nil
+}
+
+// Stop ends proxying. Requests to /v1/* go back through the normal
+// scripted emulation path.
+func (r *Recorder) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.active = false
+}
+
+// Active reports whether recording is currently in progress.
+func (r *Recorder) Active() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.active
+}
+
+// ProxyAndRecord forwards req to the configured upstream, capturing the
+// round trip into the Recorder's Log under tokenHash. It's only valid to
+// call while Active.
+func (rec *Recorder) ProxyAndRecord(w http.ResponseWriter, req *http.Request, tokenHash string) {
+	rec.mu.Lock()
+	upstream := rec.upstream
+	rec.mu.Unlock()
+
+	start := time.Now()
+
+	requestBody, _ := io.ReadAll(req.Body)
+	req.Body = io.NopCloser(bytes.NewReader(requestBody))
+
+	capture := &capturingWriter{ResponseWriter: w, status: http.StatusOK}
+
+	proxy := httputil.NewSingleHostReverseProxy(upstream)
+	proxy.ServeHTTP(capture, req)
+
+	rec.log.Record(Entry{
+		Time:             start,
+		Method:           req.Method,
+		Path:             req.URL.Path,
+		TokenHash:        tokenHash,
+		Request:          requestBody,
+		MatchedRuleIndex: -1,
+		Status:           capture.status,
+		Response:         capture.body.Bytes(),
+		ResponseBytes:    capture.body.Len(),
+		Latency:          time.Since(start),
+	})
+}
+
+// capturingWriter tees everything written through it into an in-memory
+// buffer so ProxyAndRecord can audit the upstream's real response after
+// ServeHTTP returns, while still streaming it to the original client.
+type capturingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (c *capturingWriter) WriteHeader(status int) {
+	c.status = status
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *capturingWriter) Write(b []byte) (int, error) {
+	c.body.Write(b)
+	return c.ResponseWriter.Write(b)
+}
+
+func (c *capturingWriter) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,33 @@
+package audit
+
+import "github.com/fabianvf/llemulator/internal/script"
+
+// ToScript converts recorded proxy turns (MatchedRuleIndex == -1 entries,
+// as produced by Recorder) into a Script of exact-match rules, one per
+// turn, in recording order. Replaying the script reproduces the real
+// upstream's recorded responses without needing the real upstream.
+func ToScript(entries []Entry) script.Script {
+	var rules []script.Rule
+	for _, e := range entries {
+		if e.MatchedRuleIndex != -1 {
+			// Not a recorded-proxy turn (e.g. an audited scripted
+			// response) — nothing to replay against an upstream.
+			continue
+		}
+
+		rules = append(rules, script.Rule{
+			Match: script.MatchRule{
+				Method: e.Method,
+				Path:   e.Path,
+				JSON:   e.Request,
+			},
+			Times: 1,
+			Response: script.ResponseRule{
+				Status: e.Status,
+				JSON:   e.Response,
+			},
+		})
+	}
+
+	return script.Script{Rules: rules}
+}
@@ -1,5 +1,7 @@
 package models
 
+import "encoding/json"
+
 type Model struct {
 	ID        string `json:"id"`
 	Object    string `json:"object"`
@@ -13,16 +15,46 @@ type ModelList struct {
 }
 
 type ChatMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+}
+
+// ToolCall mirrors OpenAI's function-calling shape. Index is only set on
+// streamed deltas, where it identifies which tool call a fragment belongs
+// to; non-streamed messages omit it.
+type ToolCall struct {
+	Index    *int             `json:"index,omitempty"`
+	ID       string           `json:"id,omitempty"`
+	Type     string           `json:"type,omitempty"`
+	Function ToolCallFunction `json:"function"`
+}
+
+type ToolCallFunction struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
 }
 
 type ChatCompletionRequest struct {
-	Model       string         `json:"model"`
-	Messages    []ChatMessage  `json:"messages"`
+	Model       string        `json:"model"`
+	Messages    []ChatMessage `json:"messages"`
 	MaxTokens   *int          `json:"max_tokens,omitempty"`
 	Temperature *float32      `json:"temperature,omitempty"`
 	Stream      bool          `json:"stream"`
+	Tools       []Tool        `json:"tools,omitempty"`
+	ToolChoice  interface{}   `json:"tool_choice,omitempty"`
+}
+
+type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+type ToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
 }
 
 type ChatChoice struct {
@@ -70,6 +102,26 @@ type TextCompletion struct {
 	Usage   *Usage           `json:"usage,omitempty"`
 }
 
+// EmbeddingObject is one vector in an EmbeddingResponse's Data list.
+type EmbeddingObject struct {
+	Object    string    `json:"object"`
+	Index     int       `json:"index"`
+	Embedding []float64 `json:"embedding"`
+}
+
+type EmbeddingResponse struct {
+	Object string            `json:"object"`
+	Data   []EmbeddingObject `json:"data"`
+	Model  string            `json:"model"`
+	Usage  *Usage            `json:"usage,omitempty"`
+}
+
+// TranscriptionResponse is the default ("json") response_format shape for
+// /v1/audio/transcriptions.
+type TranscriptionResponse struct {
+	Text string `json:"text"`
+}
+
 type ErrorResponse struct {
 	Error ErrorDetail `json:"error"`
 }
@@ -0,0 +1,131 @@
+package script
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestPatternCaptureExpandsInContent verifies a Pattern rule's numbered
+// and named capture groups are substituted into Content using $1/${name}
+// placeholders, independent of the {{1}} ContentTemplate syntax.
+func TestPatternCaptureExpandsInContent(t *testing.T) {
+	engine := NewEngine()
+	token := "capture-content-token"
+
+	err := engine.LoadScript(token, Script{
+		Reset: true,
+		Rules: []Rule{{
+			Match: MatchRule{Pattern: `my name is (?P<name>\w+)`},
+			Times: 1,
+			Response: ResponseRule{
+				Status:  200,
+				Content: "Nice to meet you, ${name} (aka $1)!",
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("failed to load script: %v", err)
+	}
+
+	body := []byte(`{"messages":[{"role":"user","content":"my name is Ada"}]}`)
+	response, err := engine.MatchRequest(token, "POST", "/v1/chat/completions", body)
+	if err != nil {
+		t.Fatalf("MatchRequest: %v", err)
+	}
+
+	want := "Nice to meet you, Ada (aka Ada)!"
+	if response.Content != want {
+		t.Errorf("Content = %q, want %q", response.Content, want)
+	}
+}
+
+// TestPatternCaptureExpandsInJSONLeaves verifies captures are substituted
+// into every string leaf of ResponseRule.JSON, leaving structure intact.
+func TestPatternCaptureExpandsInJSONLeaves(t *testing.T) {
+	engine := NewEngine()
+	token := "capture-json-token"
+
+	err := engine.LoadScript(token, Script{
+		Reset: true,
+		Rules: []Rule{{
+			Match: MatchRule{Pattern: `order (?P<id>\d+)`},
+			Times: 1,
+			Response: ResponseRule{
+				Status: 200,
+				JSON:   json.RawMessage(`{"choices":[{"message":{"content":"order ${id} confirmed"}}],"id":"$1"}`),
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("failed to load script: %v", err)
+	}
+
+	body := []byte(`{"messages":[{"role":"user","content":"order 42 please"}]}`)
+	response, err := engine.MatchRequest(token, "POST", "/v1/chat/completions", body)
+	if err != nil {
+		t.Fatalf("MatchRequest: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(response.JSON, &decoded); err != nil {
+		t.Fatalf("unmarshal response.JSON: %v", err)
+	}
+	if decoded["id"] != "42" {
+		t.Errorf("id = %v, want %q", decoded["id"], "42")
+	}
+	choices := decoded["choices"].([]interface{})
+	message := choices[0].(map[string]interface{})["message"].(map[string]interface{})
+	if message["content"] != "order 42 confirmed" {
+		t.Errorf("message.content = %v, want %q", message["content"], "order 42 confirmed")
+	}
+}
+
+// TestNamedCaptureStoredInSessionVars verifies a named Pattern capture is
+// written into the session's vars scratchpad without an explicit SetVars
+// entry, so a later rule's SessionRef can reference it.
+func TestNamedCaptureStoredInSessionVars(t *testing.T) {
+	engine := NewEngine()
+	token := "session-ref-token"
+
+	err := engine.LoadScript(token, Script{
+		Reset: true,
+		Rules: []Rule{
+			{
+				Match:    MatchRule{Pattern: `my name is (?P<last_user>\w+)`},
+				Times:    1,
+				Response: ResponseRule{Status: 200, Content: "hi"},
+			},
+			{
+				Match: MatchRule{
+					Pattern: `who am i, (?P<claimed>\w+)`,
+					Session: &SessionRefRule{Equals: map[string]string{"last_user": "${claimed}"}},
+				},
+				Times:    1,
+				Response: ResponseRule{Status: 200, Content: "you are indeed ${claimed}"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to load script: %v", err)
+	}
+
+	if _, err := engine.MatchRequest(token, "POST", "/v1/chat/completions",
+		[]byte(`{"messages":[{"role":"user","content":"my name is Ada"}]}`)); err != nil {
+		t.Fatalf("first turn: %v", err)
+	}
+
+	// A mismatched claim should not match the SessionRef rule.
+	if _, err := engine.MatchRequest(token, "POST", "/v1/chat/completions",
+		[]byte(`{"messages":[{"role":"user","content":"who am i, Grace"}]}`)); err == nil {
+		t.Error("expected a mismatched SessionRef claim to not match")
+	}
+
+	response, err := engine.MatchRequest(token, "POST", "/v1/chat/completions",
+		[]byte(`{"messages":[{"role":"user","content":"who am i, Ada"}]}`))
+	if err != nil {
+		t.Fatalf("matching claim: %v", err)
+	}
+	if response.Content != "you are indeed Ada" {
+		t.Errorf("Content = %q, want %q", response.Content, "you are indeed Ada")
+	}
+}
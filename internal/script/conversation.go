@@ -0,0 +1,135 @@
+package script
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// conversationState tracks progress through a single multi-turn thread, as
+// identified by conversationKey.
+type conversationState struct {
+	turn int
+}
+
+// trackConversationTurn identifies which multi-turn thread requestJSON
+// belongs to (by hashing its first message; see conversationKey), bumps
+// that thread's turn counter, and returns the new turn number. The
+// first request in a thread is turn 1.
+func (s *SessionState) trackConversationTurn(requestJSON map[string]interface{}) int {
+	if s.conversations == nil {
+		s.conversations = make(map[string]*conversationState)
+	}
+
+	key := conversationKey(requestJSON)
+	conv, ok := s.conversations[key]
+	if !ok {
+		conv = &conversationState{}
+		s.conversations[key] = conv
+	}
+	conv.turn++
+	return conv.turn
+}
+
+// conversationKey hashes requestJSON's first message, which stays the same
+// across every turn of a thread as later messages are appended after it,
+// so all requests in that thread map to the same key. Two threads
+// that happen to share a token but start from different first messages
+// (including a leading system message) get independent keys and turn
+// counters.
+func conversationKey(requestJSON map[string]interface{}) string {
+	messages, _ := requestJSON["messages"].([]interface{})
+	if len(messages) == 0 {
+		return ""
+	}
+
+	first, err := json.Marshal(messages[0])
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(first)
+	return hex.EncodeToString(sum[:])
+}
+
+// templatePattern matches {{1}}, {{2}}, {{vars.name}} placeholders.
+var templatePattern = regexp.MustCompile(`\{\{\s*([^}]+?)\s*\}\}`)
+
+// expandTemplate replaces {{1}}, {{2}}, etc. in tmpl with the corresponding
+// regex capture group from captures, and {{vars.x}} with vars["x"].
+// Unrecognized placeholders are left as-is.
+func expandTemplate(tmpl string, captures []string, vars map[string]string) string {
+	return templatePattern.ReplaceAllStringFunc(tmpl, func(match string) string {
+		key := templatePattern.FindStringSubmatch(match)[1]
+
+		if n, err := strconv.Atoi(key); err == nil {
+			if n >= 0 && n < len(captures) {
+				return captures[n]
+			}
+			return ""
+		}
+
+		if name, ok := strings.CutPrefix(key, "vars."); ok {
+			return vars[name]
+		}
+
+		return match
+	})
+}
+
+// whenPattern matches a single comparison of the form "turn > 2" or
+// `vars.mode == "angry"`.
+var whenPattern = regexp.MustCompile(`^(turn|vars\.\w+)\s*(==|!=|>=|<=|>|<)\s*(.+)$`)
+
+// evalWhen evaluates a MatchRule.When expression against the session's
+// current turn number and vars scratchpad. It supports a single comparison
+// between "turn" or "vars.name" and a quoted string or bare integer
+// literal; anything it can't parse evaluates to false so a typo in a
+// script's When expression fails closed rather than matching everything.
+func evalWhen(expr string, turn int, vars map[string]string) bool {
+	m := whenPattern.FindStringSubmatch(strings.TrimSpace(expr))
+	if m == nil {
+		return false
+	}
+	operand, op, literal := m[1], m[2], strings.TrimSpace(m[3])
+
+	var lhs string
+	if operand == "turn" {
+		lhs = strconv.Itoa(turn)
+	} else {
+		lhs = vars[strings.TrimPrefix(operand, "vars.")]
+	}
+	rhs := strings.Trim(literal, `"`)
+
+	if lhsNum, errL := strconv.Atoi(lhs); errL == nil {
+		if rhsNum, errR := strconv.Atoi(rhs); errR == nil {
+			switch op {
+			case "==":
+				return lhsNum == rhsNum
+			case "!=":
+				return lhsNum != rhsNum
+			case ">":
+				return lhsNum > rhsNum
+			case "<":
+				return lhsNum < rhsNum
+			case ">=":
+				return lhsNum >= rhsNum
+			case "<=":
+				return lhsNum <= rhsNum
+			}
+		}
+	}
+
+	switch op {
+	case "==":
+		return lhs == rhs
+	case "!=":
+		return lhs != rhs
+	default:
+		// Ordering comparisons only make sense numerically.
+		return false
+	}
+}
@@ -0,0 +1,143 @@
+package script
+
+import "testing"
+
+// TestWhenTurnGatesMultiTurnRule verifies that a rule gated on "turn > 1"
+// only matches once the conversation has advanced past its first turn, and
+// that ContentTemplate expands a prior turn's SetVars capture.
+func TestWhenTurnGatesMultiTurnRule(t *testing.T) {
+	engine := NewEngine()
+	token := "conversation-token"
+
+	script := Script{
+		Reset: true,
+		Rules: []Rule{
+			{
+				Match: MatchRule{Pattern: "my name is (\\w+)", When: "turn == 1"},
+				Times: 1,
+				Response: ResponseRule{
+					Status:  200,
+					Content: "Nice to meet you!",
+					SetVars: map[string]string{"name": "{{1}}"},
+				},
+			},
+			{
+				Match: MatchRule{Pattern: "(?i)how are you", When: "turn > 1"},
+				Times: 1,
+				Response: ResponseRule{
+					Status:          200,
+					ContentTemplate: "Hi {{vars.name}}, I'm doing well!",
+				},
+			},
+		},
+	}
+	if err := engine.LoadScript(token, script); err != nil {
+		t.Fatalf("failed to load script: %v", err)
+	}
+
+	body1 := []byte(`{"messages":[{"role":"user","content":"hi, my name is Alice"}]}`)
+	response1, err := engine.MatchRequest(token, "POST", "/v1/chat/completions", body1)
+	if err != nil {
+		t.Fatalf("failed to match first turn: %v", err)
+	}
+	if response1.Content != "Nice to meet you!" {
+		t.Errorf("expected the turn-1 greeting, got %q", response1.Content)
+	}
+
+	body2 := []byte(`{"messages":[
+		{"role":"user","content":"hi, my name is Alice"},
+		{"role":"assistant","content":"Nice to meet you!"},
+		{"role":"user","content":"how are you?"}
+	]}`)
+	response2, err := engine.MatchRequest(token, "POST", "/v1/chat/completions", body2)
+	if err != nil {
+		t.Fatalf("failed to match second turn: %v", err)
+	}
+	if response2.Content != "Hi Alice, I'm doing well!" {
+		t.Errorf("expected the captured name to be templated in, got %q", response2.Content)
+	}
+}
+
+// TestWhenVarsComparesScratchpad verifies a When expression can branch on a
+// var set by an earlier turn rather than just the turn number.
+func TestWhenVarsComparesScratchpad(t *testing.T) {
+	engine := NewEngine()
+	token := "vars-token"
+
+	script := Script{
+		Reset: true,
+		Rules: []Rule{
+			{
+				Match: MatchRule{Pattern: "i'm (angry|happy)"},
+				Times: 1,
+				Response: ResponseRule{
+					Status:  200,
+					Content: "Got it.",
+					SetVars: map[string]string{"mood": "{{1}}"},
+				},
+			},
+			{
+				Match: MatchRule{Pattern: "(?i)help", When: `vars.mood == "angry"`},
+				Times: 1,
+				Response: ResponseRule{
+					Status:  200,
+					Content: "I'm sorry you're upset. Let's fix this.",
+				},
+			},
+			{
+				Match: MatchRule{Pattern: "(?i)help", When: `vars.mood == "happy"`},
+				Times: 1,
+				Response: ResponseRule{
+					Status:  200,
+					Content: "Happy to help!",
+				},
+			},
+		},
+	}
+	if err := engine.LoadScript(token, script); err != nil {
+		t.Fatalf("failed to load script: %v", err)
+	}
+
+	body1 := []byte(`{"messages":[{"role":"user","content":"i'm angry about this"}]}`)
+	if _, err := engine.MatchRequest(token, "POST", "/v1/chat/completions", body1); err != nil {
+		t.Fatalf("failed to match mood-setting turn: %v", err)
+	}
+
+	body2 := []byte(`{"messages":[
+		{"role":"user","content":"i'm angry about this"},
+		{"role":"assistant","content":"Got it."},
+		{"role":"user","content":"can you help?"}
+	]}`)
+	response2, err := engine.MatchRequest(token, "POST", "/v1/chat/completions", body2)
+	if err != nil {
+		t.Fatalf("failed to match help turn: %v", err)
+	}
+	if response2.Content != "I'm sorry you're upset. Let's fix this." {
+		t.Errorf("expected the angry-mood branch to win, got %q", response2.Content)
+	}
+}
+
+// TestConversationKeyDistinguishesThreads verifies that two unrelated
+// conversations (different message history) don't share a turn counter.
+func TestConversationKeyDistinguishesThreads(t *testing.T) {
+	engine := NewEngine()
+	token := "threads-token"
+
+	engine.LoadScript(token, Script{Reset: true, Rules: []Rule{
+		{
+			Match:    MatchRule{Pattern: "(?i)hello", When: "turn == 1"},
+			Times:    999,
+			Response: ResponseRule{Status: 200, Content: "Hi there!"},
+		},
+	}})
+
+	threadA := []byte(`{"messages":[{"role":"user","content":"hello"}]}`)
+	threadB := []byte(`{"messages":[{"role":"system","content":"different context"},{"role":"user","content":"hello"}]}`)
+
+	if _, err := engine.MatchRequest(token, "POST", "/v1/chat/completions", threadA); err != nil {
+		t.Fatalf("failed to match thread A turn 1: %v", err)
+	}
+	if _, err := engine.MatchRequest(token, "POST", "/v1/chat/completions", threadB); err != nil {
+		t.Fatalf("expected thread B's own first turn to match turn == 1, got error: %v", err)
+	}
+}
@@ -0,0 +1,24 @@
+package script
+
+// defaultEmbeddingDims is used when a script doesn't configure
+// EmbeddingDims for the requested model, matching OpenAI's
+// text-embedding-ada-002 vector length.
+const defaultEmbeddingDims = 1536
+
+// EmbeddingDims returns the embedding vector length configured for model
+// via Script.EmbeddingDims, or defaultEmbeddingDims if the session has no
+// override for model (or no script is loaded for token at all).
+func (e *Engine) EmbeddingDims(token, model string) int {
+	session := e.getSession(token)
+	if session == nil {
+		return defaultEmbeddingDims
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if dims, ok := session.embeddingDims[model]; ok && dims > 0 {
+		return dims
+	}
+	return defaultEmbeddingDims
+}
@@ -7,6 +7,10 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/fabianvf/llemulator/internal/script/transform"
+	"github.com/fabianvf/llemulator/internal/session"
 )
 
 type MatchRule struct {
@@ -14,17 +18,208 @@ type MatchRule struct {
 	Path    string          `json:"path"`
 	JSON    json.RawMessage `json:"json,omitempty"`
 	Pattern string          `json:"pattern,omitempty"`
+
+	// When is a small expression evaluated against the session's current
+	// turn number and vars scratchpad, e.g. "turn > 2" or
+	// `vars.mode == "angry"`. A rule whose When expression evaluates to
+	// false is skipped even if Pattern/Method/Path/JSON otherwise match. See
+	// evalWhen for the expressions this supports.
+	When string `json:"when,omitempty"`
+
+	// HasImage and ImageCount gate on the multimodal content parts
+	// (type "image_url") of the request's last user message, letting a
+	// script distinguish an image-carrying chat completion from a
+	// text-only one without hand-parsing the content array itself. Both
+	// are pointers so "unset" (match regardless) is distinguishable from
+	// an explicit false/0.
+	HasImage   *bool `json:"has_image,omitempty"`
+	ImageCount *int  `json:"image_count,omitempty"`
+
+	// Script, if set, is run (via script/transform) as this rule's match
+	// decision instead of — or alongside — Pattern/Method/Path/JSON/When:
+	// all of those still gate first, and Script runs last, for logic too
+	// dynamic to express declaratively. ScriptLang selects the transform
+	// Engine that runs it ("js" or "lua"); empty defaults to "js".
+	Script     string `json:"script,omitempty"`
+	ScriptLang string `json:"script_lang,omitempty"`
+
+	// Session gates this rule on the session's vars scratchpad, letting a
+	// later turn check it's responding about the same entity an earlier
+	// turn's named or numbered Pattern capture stored there. See
+	// SessionRefRule and matchesSessionRef.
+	Session *SessionRefRule `json:"session,omitempty"`
+
+	// SessionMatch gates this rule on the caller's internal/session.Session
+	// data (not the vars scratchpad Session above), using the same
+	// subset-match semantics as JSON: every field present here must equal
+	// the corresponding field of the session's data, e.g. {"turn": 3,
+	// "tool_called": "search"}. A non-empty SessionMatch never matches if
+	// the request carries no session, e.g. MatchRequest rather than
+	// MatchRequestWithIndex's session-aware caller.
+	SessionMatch json.RawMessage `json:"session_match,omitempty"`
+}
+
+// SessionRefRule compares the session's vars scratchpad against expected
+// values. Each Equals value may use $1/${name} placeholders, which are
+// expanded against this match attempt's own Pattern captures before the
+// comparison runs.
+type SessionRefRule struct {
+	Equals map[string]string `json:"equals,omitempty"`
 }
 
 type ResponseRule struct {
-	Status  int             `json:"status"`
-	Content string          `json:"content,omitempty"`
-	JSON    json.RawMessage `json:"json,omitempty"`
-	SSE     []SSEEvent      `json:"sse,omitempty"`
+	Status    int             `json:"status"`
+	Content   string          `json:"content,omitempty"`
+	JSON      json.RawMessage `json:"json,omitempty"`
+	SSE       []SSEEvent      `json:"sse,omitempty"`
+	Timing    *Timing         `json:"timing,omitempty"`
+	ToolCalls []ToolCallSpec  `json:"tool_calls,omitempty"`
+
+	// SetVars writes into the session's vars scratchpad when this rule
+	// matches, so a later turn's When expression or ContentTemplate can
+	// reference what an earlier turn captured. Values may themselves use
+	// the {{1}}/{{vars.x}} template syntax (see expandTemplate).
+	SetVars map[string]string `json:"set_vars,omitempty"`
+
+	// ContentTemplate, if set, overrides Content and is expanded before the
+	// response is returned: {{1}}, {{2}}, etc. are replaced with the
+	// matching rule's regex capture groups, and {{vars.x}} is replaced with
+	// the session's vars scratchpad entry "x".
+	ContentTemplate string `json:"content_template,omitempty"`
+
+	// Fault, if set, tells the server to simulate a transport or
+	// provider-level failure instead of writing a normal completion for
+	// this rule. See Fault for the kinds it supports.
+	Fault *Fault `json:"fault,omitempty"`
+
+	// InitialDelayMs delays the first byte of a streamed response by this
+	// many milliseconds, so a script can model a slow time-to-first-byte
+	// (e.g. provider queueing) on a rule that otherwise has no Timing of
+	// its own. If the rule's Timing sets FirstTokenMs, that value is used
+	// and InitialDelayMs is ignored for the generated-chunk streams.
+	InitialDelayMs int `json:"initial_delay_ms,omitempty"`
+
+	// FailAfter, if set, truncates an in-flight SSE stream with a
+	// scripted error partway through instead of completing normally, for
+	// testing a client's retry/abort handling against a stream that
+	// degrades mid-flight. See FailureSpec.
+	FailAfter *FailureSpec `json:"fail_after,omitempty"`
+
+	// Script, if set, is run (via script/transform) to synthesize this
+	// rule's response in place of Content/JSON/ContentTemplate — useful
+	// when the response depends on dynamic logic (rolling context,
+	// conditional tool-call shapes) rather than a fixed template.
+	// ScriptLang selects the transform Engine ("js" or "lua"); empty
+	// defaults to "js".
+	Script     string `json:"script,omitempty"`
+	ScriptLang string `json:"script_lang,omitempty"`
+
+	// SessionUpdate writes into the caller's internal/session.Session data
+	// when this rule matches, JSON-merged in (each field present here
+	// overwrites or adds that field of the session's data; fields it
+	// omits are left alone). $1/${name} capture placeholders in its string
+	// leaves are expanded first; {{1}}/{{vars.x}} template placeholders are
+	// not expanded. So a rule can stash the last user message, record
+	// which tool was called, or advance a turn counter it read back via
+	// Match.SessionMatch. It's a no-op if the request carries no session.
+	SessionUpdate json.RawMessage `json:"session_update,omitempty"`
+}
+
+// Fault describes a scripted failure mode. Testing client resilience
+// against 429s, 5xxs, malformed SSE, and dropped connections is a primary
+// use case for a mock LLM server, so these get first-class fields rather
+// than requiring a script author to hand-roll them via Status/Content.
+type Fault struct {
+	// Kind selects which failure to simulate. See the Fault* constants.
+	Kind string `json:"kind"`
+
+	// RetryAfterMs is the delay the fault reports or waits on: for
+	// FaultRateLimit it's the Retry-After/x-ratelimit-reset value; for
+	// FaultSlowBody it's the delay between each byte written.
+	RetryAfterMs int `json:"retry_after_ms,omitempty"`
+
+	// AfterBytes is how many bytes of a streamed SSE response to write
+	// before FaultTruncateStream cuts the connection.
+	AfterBytes int `json:"after_bytes,omitempty"`
+}
+
+const (
+	// FaultRateLimit emits an OpenAI-shaped 429 with Retry-After and
+	// x-ratelimit-* headers.
+	FaultRateLimit = "rate_limit"
+	// FaultServerError emits a 5xx error body (Status if set, else 503).
+	FaultServerError = "server_error"
+	// FaultTimeout never writes a response, holding the connection open
+	// until the client gives up or its request context is cancelled.
+	FaultTimeout = "timeout"
+	// FaultTruncateStream writes AfterBytes of plausible SSE chunks, then
+	// closes the connection without a terminating [DONE] event.
+	FaultTruncateStream = "truncate_stream"
+	// FaultInvalidSSE writes a single malformed "data:" frame and stops.
+	FaultInvalidSSE = "invalid_sse"
+	// FaultSlowBody writes a normal JSON completion body one byte at a
+	// time, RetryAfterMs apart.
+	FaultSlowBody = "slow_body"
+)
+
+// FailureSpec describes a mid-stream failure a scripted SSE response
+// should surface after a threshold is crossed. Events and AfterMs are
+// independent triggers; whichever is reached first ends the stream with
+// Kind (one of the Fault* kinds, e.g. FaultTruncateStream or
+// FaultInvalidSSE) at Status, the same failure vocabulary Fault itself
+// uses for a whole-response failure.
+type FailureSpec struct {
+	// Events stops the stream after this many SSE events have been
+	// written. Zero means this trigger is disabled.
+	Events int `json:"events,omitempty"`
+	// AfterMs stops the stream once this many milliseconds have elapsed
+	// since it started. Zero means this trigger is disabled.
+	AfterMs int `json:"after_ms,omitempty"`
+	// Kind selects how the stream ends; see the Fault* constants. Defaults
+	// to FaultTruncateStream.
+	Kind string `json:"kind,omitempty"`
+	// Status is the status code reported for Kind values that carry one
+	// (e.g. FaultServerError).
+	Status int `json:"status,omitempty"`
+}
+
+// ToolCallSpec describes a single tool call for the engine to emit in a
+// scripted response. Arguments is normally a literal JSON string, but it may
+// also reference capture groups from the matching rule's Match.Pattern
+// using $1, $2, etc., which are substituted from the regex match against
+// the request's user message before the response is returned.
+type ToolCallSpec struct {
+	ID        string `json:"id,omitempty"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// Timing lets a scripted response model realistic streaming behavior
+// instead of the writers' previous hard-coded 10ms inter-chunk delay.
+// FirstTokenMs and InterTokenMs pace the stream; JitterMs adds up to that
+// many extra milliseconds to every inter-token delay. StallAfterTokens, if
+// set, stops delivering further tokens once that many have been sent,
+// leaving the stream open so a script can exercise client-side timeout
+// handling; AbortAfterMs arms the session's write deadline (see
+// SessionState.ArmWriteDeadline) so the stream is cut instead.
+type Timing struct {
+	FirstTokenMs     int `json:"first_token_ms,omitempty"`
+	InterTokenMs     int `json:"inter_token_ms,omitempty"`
+	JitterMs         int `json:"jitter_ms,omitempty"`
+	StallAfterTokens int `json:"stall_after_tokens,omitempty"`
+	AbortAfterMs     int `json:"abort_after_ms,omitempty"`
 }
 
 type SSEEvent struct {
 	Data json.RawMessage `json:"data"`
+
+	// DelayMs and JitterMs set the pause written after this event, before
+	// the next one, for a scripted SSE sequence that wants bespoke
+	// per-event timing (e.g. a long pause after a particular chunk) rather
+	// than one uniform inter-event delay. A zero DelayMs falls back to the
+	// writer's 10ms default; a zero JitterMs adds no jitter (see eventDelay).
+	DelayMs  int `json:"delay_ms,omitempty"`
+	JitterMs int `json:"jitter_ms,omitempty"`
 }
 
 type Rule struct {
@@ -38,6 +233,26 @@ type Script struct {
 	Rules     []Rule            `json:"rules"`
 	Responses interface{}       `json:"responses,omitempty"`
 	Defaults  DefaultSettings   `json:"defaults"`
+
+	// RateLimits caps requests-per-minute and tokens-per-minute per model,
+	// enforced by Engine.CheckRateLimit before any rule is matched. Absent
+	// entries (the common case) mean that model is unmetered.
+	RateLimits map[string]RateLimitPolicy `json:"rate_limits,omitempty"`
+
+	// EmbeddingDims sets the vector length /v1/embeddings returns for a
+	// given model. A model absent from this map gets defaultEmbeddingDims.
+	EmbeddingDims map[string]int `json:"embedding_dims,omitempty"`
+
+	// TimeoutMs bounds how long the server will work on a request against
+	// this token before giving up with a deadline_exceeded error, enforced
+	// via a context.WithTimeout derived from the request's own context. Zero
+	// (the default) means no deadline beyond the client's own.
+	TimeoutMs int `json:"timeout_ms,omitempty"`
+
+	// Models restricts which model IDs a request against this token may
+	// use, checked by Engine.ValidateModel and listed by Engine.GetModels.
+	// Absent or empty (the common case) falls back to defaultModels.
+	Models []string `json:"models,omitempty"`
 }
 
 type DefaultSettings struct {
@@ -52,6 +267,91 @@ type Engine struct {
 type SessionState struct {
 	mu    sync.Mutex
 	rules []Rule
+
+	// conversations tracks how many turns have been seen for each distinct
+	// multi-turn thread, keyed by a hash of the request's first message
+	// (see conversationKey). vars is a scratchpad of named strings
+	// a rule's SetVars can populate and a later rule's When/ContentTemplate
+	// can read back, so a script can branch across turns without a rule per
+	// turn-and-value combination.
+	conversations map[string]*conversationState
+	vars          map[string]string
+
+	// rateLimits and rateLimitWindows back Engine.CheckRateLimit: rateLimits
+	// is the policy loaded from Script.RateLimits, keyed by model, and
+	// rateLimitWindows is each of those models' current one-minute usage
+	// window.
+	rateLimits       map[string]RateLimitPolicy
+	rateLimitWindows map[string]*rateLimitWindow
+
+	// embeddingDims is the per-model vector length loaded from
+	// Script.EmbeddingDims, read by Engine.EmbeddingDims.
+	embeddingDims map[string]int
+
+	// requestTimeoutMs is loaded from Script.TimeoutMs, read by
+	// Engine.RequestTimeoutMs.
+	requestTimeoutMs int
+
+	// models is loaded from Script.Models, read by Engine.GetModels and
+	// Engine.ValidateModel. Empty means the token hasn't restricted its
+	// models, so those fall back to defaultModels.
+	models []string
+
+	// cancelMu guards readCancelCh/writeCancelCh independently of mu so
+	// arming a deadline never contends with rule matching. Each channel is
+	// replaced (not reused) when armed, modeled on the net package's
+	// deadlineTimer pattern: a streaming loop holds whatever channel it read
+	// via WriteCancelCh/ReadCancelCh for the life of that stream, so a later
+	// re-arm only affects streams that ask for the channel again.
+	cancelMu      sync.Mutex
+	readCancelCh  chan struct{}
+	writeCancelCh chan struct{}
+
+	// transformPrograms caches each rule's compiled Match.Script/
+	// Response.Script (see transform.go) keyed by "<lang>:<source>", so
+	// repeated requests against the same rule reuse the parsed program
+	// instead of recompiling it every time.
+	transformPrograms map[string]transform.Program
+}
+
+// ArmWriteDeadline models a scripted mid-stream abort ("aborts after
+// 500ms"): it installs a fresh write-cancel channel and closes it once d
+// elapses, so any streaming writer selecting on WriteCancelCh observes the
+// abort without the engine holding a reference to that writer.
+func (s *SessionState) ArmWriteDeadline(d time.Duration) {
+	s.cancelMu.Lock()
+	defer s.cancelMu.Unlock()
+	ch := make(chan struct{})
+	s.writeCancelCh = ch
+	time.AfterFunc(d, func() { close(ch) })
+}
+
+// WriteCancelCh returns the session's current write-cancel channel, or nil
+// if no deadline is armed. A nil channel blocks forever in a select, which
+// is the desired no-deadline behavior.
+func (s *SessionState) WriteCancelCh() <-chan struct{} {
+	s.cancelMu.Lock()
+	defer s.cancelMu.Unlock()
+	return s.writeCancelCh
+}
+
+// ArmReadDeadline is the read-side counterpart of ArmWriteDeadline, for
+// scripts that need to simulate a client read (e.g. a request body) hanging
+// rather than a response write.
+func (s *SessionState) ArmReadDeadline(d time.Duration) {
+	s.cancelMu.Lock()
+	defer s.cancelMu.Unlock()
+	ch := make(chan struct{})
+	s.readCancelCh = ch
+	time.AfterFunc(d, func() { close(ch) })
+}
+
+// ReadCancelCh returns the session's current read-cancel channel, or nil if
+// no deadline is armed.
+func (s *SessionState) ReadCancelCh() <-chan struct{} {
+	s.cancelMu.Lock()
+	defer s.cancelMu.Unlock()
+	return s.readCancelCh
 }
 
 func NewEngine() *Engine {
@@ -78,7 +378,10 @@ func (e *Engine) LoadScript(token string, script Script) error {
 	session, exists := e.sessions[token]
 	if !exists || script.Reset {
 		session = &SessionState{
-			rules: make([]Rule, len(rules)),
+			rules:            make([]Rule, len(rules)),
+			conversations:    make(map[string]*conversationState),
+			vars:             make(map[string]string),
+			rateLimitWindows: make(map[string]*rateLimitWindow),
 		}
 		e.sessions[token] = session
 	}
@@ -87,20 +390,66 @@ func (e *Engine) LoadScript(token string, script Script) error {
 		session.rules[i] = rule
 	}
 
+	if script.RateLimits != nil {
+		session.rateLimits = script.RateLimits
+	}
+
+	if script.EmbeddingDims != nil {
+		session.embeddingDims = script.EmbeddingDims
+	}
+
+	if script.TimeoutMs > 0 {
+		session.requestTimeoutMs = script.TimeoutMs
+	}
+
+	if len(script.Models) > 0 {
+		session.models = script.Models
+	}
+
 	return nil
 }
 
 func (e *Engine) MatchRequest(token, method, path string, body []byte) (*ResponseRule, error) {
-	session := e.getSession(token)
-	if session == nil {
-		return nil, fmt.Errorf("no script loaded for token")
+	response, _, err := e.MatchRequestWithIndex(token, method, path, body, nil)
+	return response, err
+}
+
+// MatchRequestWithIndex is MatchRequest plus the index into the session's
+// rule list that answered the request, for callers (the audit subsystem)
+// that need to record which rule matched. The index is -1 if no rule
+// matched. sess is the caller's internal/session.Session for token (looked
+// up via session.Manager.GetOrCreateSession), or nil if the caller has no
+// use for Match.SessionMatch/Response.SessionUpdate; when non-nil, the
+// match-and-update runs inside sess.ExecuteWithData so a rule's session
+// gate and its session mutation observe and apply atomically against
+// concurrent requests on the same token.
+func (e *Engine) MatchRequestWithIndex(token, method, path string, body []byte, sess *session.Session) (*ResponseRule, int, error) {
+	scriptSession := e.getSession(token)
+	if scriptSession == nil {
+		return nil, -1, fmt.Errorf("no script loaded for token")
 	}
 
-	session.mu.Lock()
-	defer session.mu.Unlock()
+	scriptSession.mu.Lock()
+	defer scriptSession.mu.Unlock()
 
 	requestJSON := parseRequestBody(body)
-	return findMatchingRule(session, method, path, requestJSON)
+	turn := scriptSession.trackConversationTurn(requestJSON)
+
+	var response *ResponseRule
+	var idx int
+	var err error
+	if sess != nil {
+		sess.ExecuteWithData(func(data map[string]interface{}) {
+			response, idx, err = findMatchingRule(scriptSession, method, path, requestJSON, turn, data)
+		})
+	} else {
+		response, idx, err = findMatchingRule(scriptSession, method, path, requestJSON, turn, nil)
+	}
+
+	if err == nil && response.Timing != nil && response.Timing.AbortAfterMs > 0 {
+		scriptSession.ArmWriteDeadline(time.Duration(response.Timing.AbortAfterMs) * time.Millisecond)
+	}
+	return response, idx, err
 }
 
 func (e *Engine) getSession(token string) *SessionState {
@@ -109,6 +458,13 @@ func (e *Engine) getSession(token string) *SessionState {
 	return e.sessions[token]
 }
 
+// Session exposes a token's SessionState so callers outside this package
+// (the server's streaming writers) can arm or read its deadline channels.
+// It returns nil if no script has been loaded for token.
+func (e *Engine) Session(token string) *SessionState {
+	return e.getSession(token)
+}
+
 func parseRequestBody(body []byte) map[string]interface{} {
 	if len(body) == 0 {
 		return nil
@@ -120,20 +476,33 @@ func parseRequestBody(body []byte) map[string]interface{} {
 	return requestJSON
 }
 
-func findMatchingRule(session *SessionState, method, path string, requestJSON map[string]interface{}) (*ResponseRule, error) {
+// findMatchingRule evaluates session's rules in order against the incoming
+// request. sessionData is the caller's internal/session.Session data map
+// (nil if the caller passed no session to MatchRequestWithIndex), used for
+// Match.SessionMatch and mutated in place by a matching rule's
+// Response.SessionUpdate.
+func findMatchingRule(session *SessionState, method, path string, requestJSON map[string]interface{}, turn int, sessionData map[string]interface{}) (*ResponseRule, int, error) {
 	// Extract user message for pattern matching
-	userMessage := extractUserMessage(requestJSON)
-	
+	userMessage, imageCount := extractUserMessageParts(requestJSON)
+
 	for i, rule := range session.rules {
-		if rule.Times <= 0 {
+		// Times < 0 (conventionally -1) is the documented "unlimited"
+		// sentinel and never exhausts; only an exact 0 means used up.
+		if rule.Times == 0 {
 			continue
 		}
 
+		var captures []string
+		var namedCaptures map[string]string
+
 		// Pattern matching takes precedence
 		if rule.Match.Pattern != "" {
-			if !matchesPattern(rule.Match.Pattern, userMessage) {
+			matched, submatches, named := matchesPatternCaptures(rule.Match.Pattern, userMessage)
+			if !matched {
 				continue
 			}
+			captures = submatches
+			namedCaptures = named
 		} else {
 			// Traditional matching
 			if !matchesMethodAndPath(rule, method, path) {
@@ -145,10 +514,117 @@ func findMatchingRule(session *SessionState, method, path string, requestJSON ma
 			}
 		}
 
-		session.rules[i].Times--
-		return &rule.Response, nil
+		if rule.Match.When != "" && !evalWhen(rule.Match.When, turn, session.vars) {
+			continue
+		}
+
+		if rule.Match.HasImage != nil && (imageCount > 0) != *rule.Match.HasImage {
+			continue
+		}
+
+		if rule.Match.ImageCount != nil && imageCount != *rule.Match.ImageCount {
+			continue
+		}
+
+		if rule.Match.Session != nil && !matchesSessionRef(rule.Match.Session, session.vars, captures, namedCaptures) {
+			continue
+		}
+
+		if len(rule.Match.SessionMatch) > 0 && !matchesSessionState(rule.Match.SessionMatch, sessionData) {
+			continue
+		}
+
+		if rule.Match.Script != "" {
+			matched, err := runMatchScript(session, rule.Match.ScriptLang, rule.Match.Script, method, path, requestJSON, turn)
+			if err != nil {
+				return nil, -1, err
+			}
+			if !matched {
+				continue
+			}
+		}
+
+		if rule.Times > 0 {
+			session.rules[i].Times--
+		}
+		response := rule.Response
+		if len(response.ToolCalls) > 0 && len(captures) > 0 {
+			response.ToolCalls = expandToolCallCaptures(response.ToolCalls, captures)
+		}
+
+		// Named captures land in the vars scratchpad under their capture
+		// name, so a later turn's SessionRef or When expression can
+		// reference what this turn's pattern captured without an
+		// explicit SetVars entry.
+		if len(namedCaptures) > 0 {
+			if session.vars == nil {
+				session.vars = make(map[string]string)
+			}
+			for name, value := range namedCaptures {
+				session.vars[name] = value
+			}
+		}
+
+		if len(response.SetVars) > 0 {
+			if session.vars == nil {
+				session.vars = make(map[string]string)
+			}
+			for name, value := range response.SetVars {
+				session.vars[name] = expandTemplate(value, captures, session.vars)
+			}
+		}
+
+		if response.ContentTemplate != "" {
+			response.Content = expandTemplate(response.ContentTemplate, captures, session.vars)
+		}
+
+		if len(response.SessionUpdate) > 0 && sessionData != nil {
+			if err := applySessionUpdate(sessionData, response.SessionUpdate, captures, namedCaptures); err != nil {
+				return nil, -1, err
+			}
+		}
+
+		// $1/${name} placeholders are expanded wherever the response
+		// carries text, independent of ContentTemplate's {{1}} syntax, so
+		// a Pattern rule's captures can show up verbatim in a canned
+		// Content/JSON/SSE response without that rule needing to switch
+		// to templating.
+		response.Content = expandCaptures(response.Content, captures, namedCaptures)
+		if expandedJSON, err := expandJSONCaptures(response.JSON, captures, namedCaptures); err == nil {
+			response.JSON = expandedJSON
+		}
+		for j := range response.SSE {
+			if expanded, err := expandJSONCaptures(response.SSE[j].Data, captures, namedCaptures); err == nil {
+				response.SSE[j].Data = expanded
+			}
+		}
+
+		if response.Script != "" {
+			scripted, err := runResponseScript(session, response.ScriptLang, response.Script, method, path, requestJSON, turn)
+			if err != nil {
+				return nil, -1, err
+			}
+			response = scripted
+		}
+
+		return &response, i, nil
+	}
+	return nil, -1, fmt.Errorf("no matching rule found")
+}
+
+// expandToolCallCaptures substitutes $1, $2, etc. in each tool call's
+// Arguments with the corresponding regex capture group from the matching
+// rule's pattern, returning a new slice so the stored rule is left intact
+// for subsequent matches.
+func expandToolCallCaptures(calls []ToolCallSpec, captures []string) []ToolCallSpec {
+	expanded := make([]ToolCallSpec, len(calls))
+	for i, call := range calls {
+		for n := len(captures) - 1; n >= 1; n-- {
+			call.Arguments = strings.ReplaceAll(call.Arguments, fmt.Sprintf("$%d", n), captures[n])
+		}
+		expanded[i] = call
 	}
-	return nil, fmt.Errorf("no matching rule found")
+	return expanded
 }
 
 func matchesMethodAndPath(rule Rule, method, path string) bool {
@@ -176,48 +652,255 @@ func matchesJSON(rule Rule, requestJSON map[string]interface{}) bool {
 	return jsonContains(requestJSON, matchJSON)
 }
 
-// extractUserMessage extracts the user message from a chat completion request
+// extractUserMessage extracts the most recent user (or tool-response)
+// message from a chat completion request, so scripts can match on either a
+// user's prompt or the content of a role:"tool" turn that followed a
+// tool_calls response. It discards the image-part count extractUserMessageParts
+// also computes; use that directly where HasImage/ImageCount matter.
 func extractUserMessage(requestJSON map[string]interface{}) string {
+	text, _ := extractUserMessageParts(requestJSON)
+	return text
+}
+
+// extractUserMessageParts is extractUserMessage plus a count of how many
+// "image_url" content parts the same message carried, for
+// MatchRule.HasImage/ImageCount.
+func extractUserMessageParts(requestJSON map[string]interface{}) (text string, imageCount int) {
 	if requestJSON == nil {
-		return ""
+		return "", 0
 	}
-	
+
 	// Try to extract messages array
 	if messages, ok := requestJSON["messages"].([]interface{}); ok {
-		// Find last user message
+		// Find the last user or tool message
 		for i := len(messages) - 1; i >= 0; i-- {
-			if msg, ok := messages[i].(map[string]interface{}); ok {
-				if role, ok := msg["role"].(string); ok && role == "user" {
-					if content, ok := msg["content"].(string); ok {
-						return content
-					}
-				}
+			msg, ok := messages[i].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			role, ok := msg["role"].(string)
+			if !ok || (role != "user" && role != "tool") {
+				continue
+			}
+			switch content := msg["content"].(type) {
+			case string:
+				return content, 0
+			case []interface{}:
+				return flattenContentParts(content)
 			}
 		}
 	}
-	
+
 	// Try to extract prompt for completion endpoint
 	if prompt, ok := requestJSON["prompt"].(string); ok {
-		return prompt
+		return prompt, 0
 	}
-	
-	return ""
+
+	// /v1/audio/transcriptions requests are synthesized by the server from
+	// a multipart upload (see server.handleTranscription) with the
+	// uploaded filename here, so scripts can match on it like any other
+	// text.
+	if fileName, ok := requestJSON["file_name"].(string); ok {
+		return fileName, 0
+	}
+
+	return "", 0
+}
+
+// flattenContentParts joins a multimodal chat message's content parts
+// (each a {"type": "text"|"image_url"|"input_audio", ...} object, per the
+// OpenAI vision/audio input format) into the plain text Pattern matching
+// operates on, and counts the image_url parts seen so callers can expose
+// MatchRule.HasImage/ImageCount.
+func flattenContentParts(parts []interface{}) (text string, imageCount int) {
+	var b strings.Builder
+	for _, p := range parts {
+		part, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch part["type"] {
+		case "text":
+			if t, ok := part["text"].(string); ok {
+				if b.Len() > 0 {
+					b.WriteByte(' ')
+				}
+				b.WriteString(t)
+			}
+		case "image_url":
+			imageCount++
+		}
+	}
+	return b.String(), imageCount
 }
 
-// matchesPattern checks if text matches a regex pattern (case-insensitive)
-func matchesPattern(pattern, text string) bool {
+// matchesPatternCaptures checks if text matches a regex pattern
+// (case-insensitive) and, if so, returns the regex's submatches for callers
+// (tool call argument templating) that need the captured groups rather than
+// just a boolean.
+func matchesPatternCaptures(pattern, text string) (bool, []string, map[string]string) {
 	if pattern == "" || text == "" {
-		return false
+		return false, nil, nil
 	}
-	
-	// Compile regex with case-insensitive flag
+
 	re, err := regexp.Compile("(?i)" + pattern)
 	if err != nil {
-		// If pattern is invalid regex, try exact match
-		return strings.EqualFold(pattern, text)
+		return strings.EqualFold(pattern, text), nil, nil
 	}
-	
-	return re.MatchString(text)
+
+	submatches := re.FindStringSubmatch(text)
+	if submatches == nil {
+		return false, nil, nil
+	}
+
+	var named map[string]string
+	for i, name := range re.SubexpNames() {
+		if i == 0 || name == "" {
+			continue
+		}
+		if named == nil {
+			named = make(map[string]string)
+		}
+		named[name] = submatches[i]
+	}
+
+	return true, submatches, named
+}
+
+// expandCaptures replaces $1, $2, ... (numbered) and ${name} (named)
+// placeholders in s with the corresponding regex capture group from a
+// Pattern match. Placeholders with no corresponding capture are left
+// untouched. Named replacements run first so a name that happens to look
+// like "1" doesn't get clobbered by the numbered pass.
+func expandCaptures(s string, captures []string, named map[string]string) string {
+	if len(captures) == 0 && len(named) == 0 {
+		return s
+	}
+
+	for name, value := range named {
+		s = strings.ReplaceAll(s, "${"+name+"}", value)
+	}
+	for n := len(captures) - 1; n >= 1; n-- {
+		s = strings.ReplaceAll(s, fmt.Sprintf("$%d", n), captures[n])
+	}
+	return s
+}
+
+// expandJSONCaptures expands $1/${name} placeholders in every string leaf
+// of a JSON document, preserving its structure. raw is returned unchanged
+// if it doesn't parse as JSON (e.g. it's empty).
+func expandJSONCaptures(raw json.RawMessage, captures []string, named map[string]string) (json.RawMessage, error) {
+	if len(raw) == 0 || (len(captures) == 0 && len(named) == 0) {
+		return raw, nil
+	}
+
+	var data interface{}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return raw, err
+	}
+
+	return json.Marshal(walkExpandCaptures(data, captures, named))
+}
+
+func walkExpandCaptures(v interface{}, captures []string, named map[string]string) interface{} {
+	switch val := v.(type) {
+	case string:
+		return expandCaptures(val, captures, named)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			out[k] = walkExpandCaptures(item, captures, named)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = walkExpandCaptures(item, captures, named)
+		}
+		return out
+	default:
+		return val
+	}
+}
+
+// matchesSessionState checks a rule's Match.SessionMatch against the
+// caller's internal/session.Session data using the same subset-match
+// semantics as matchesJSON: every field raw describes must be present and
+// equal in data. A rule carrying SessionMatch never matches if the caller
+// passed no session (data is nil), since there's nothing to check it
+// against.
+func matchesSessionState(raw json.RawMessage, data map[string]interface{}) bool {
+	var want map[string]interface{}
+	if err := json.Unmarshal(raw, &want); err != nil {
+		return false
+	}
+
+	if len(want) == 0 {
+		return true
+	}
+
+	if data == nil {
+		return false
+	}
+
+	return jsonContains(data, want)
+}
+
+// applySessionUpdate JSON-merges a matching rule's Response.SessionUpdate
+// into the caller's session data: every field the update carries overwrites
+// or adds that field of data, recursing into nested objects, while fields
+// it omits are left untouched. $1/${name} placeholders in the update's
+// string leaves are expanded first, against this match's own captures,
+// before the merge; {{1}}/{{vars.x}} template placeholders are left as-is.
+func applySessionUpdate(data map[string]interface{}, raw json.RawMessage, captures []string, namedCaptures map[string]string) error {
+	var update map[string]interface{}
+	if err := json.Unmarshal(raw, &update); err != nil {
+		return fmt.Errorf("session_update: %w", err)
+	}
+
+	mergeSessionData(data, expandSessionUpdateStrings(update, captures, namedCaptures))
+	return nil
+}
+
+// expandSessionUpdateStrings expands $1/${name} capture placeholders in
+// every string leaf of v, mirroring walkExpandCaptures.
+func expandSessionUpdateStrings(v interface{}, captures []string, named map[string]string) interface{} {
+	return walkExpandCaptures(v, captures, named)
+}
+
+// mergeSessionData writes every field of patch into dst, recursing into
+// nested objects present on both sides and overwriting dst's field
+// otherwise; it's the merge counterpart of jsonContains's comparison.
+func mergeSessionData(dst map[string]interface{}, patch interface{}) {
+	patchMap, ok := patch.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	for key, value := range patchMap {
+		if nestedPatch, ok := value.(map[string]interface{}); ok {
+			if nestedDst, ok := dst[key].(map[string]interface{}); ok {
+				mergeSessionData(nestedDst, nestedPatch)
+				continue
+			}
+		}
+		dst[key] = value
+	}
+}
+
+// matchesSessionRef checks a rule's SessionRef.Equals entries against the
+// session's vars scratchpad, expanding each expected value's $1/${name}
+// placeholders against this match attempt's own captures first. This
+// lets a later turn confirm it's responding about the same entity an
+// earlier turn captured, e.g. {"session": {"equals": {"last_user":
+// "$1"}}}.
+func matchesSessionRef(ref *SessionRefRule, vars map[string]string, captures []string, named map[string]string) bool {
+	for key, want := range ref.Equals {
+		if vars[key] != expandCaptures(want, captures, named) {
+			return false
+		}
+	}
+	return true
 }
 
 // processResponses converts simplified response formats to internal rules
@@ -225,6 +908,22 @@ func processResponses(responses interface{}) ([]Rule, error) {
 	var rules []Rule
 	
 	switch v := responses.(type) {
+	case string:
+		// A bare string is shorthand for "always answer with this content",
+		// the same as a one-element []interface{} would mean except it
+		// never runs out, since there's no second response to fall through
+		// to once Times is exhausted.
+		rules = append(rules, Rule{
+			Match: MatchRule{
+				Method: "POST",
+				Path:   "/v1/chat/completions",
+			},
+			Times: -1,
+			Response: ResponseRule{
+				Status:  200,
+				Content: v,
+			},
+		})
 	case []interface{}:
 		// Sequential array format
 		for _, resp := range v {
@@ -290,6 +989,84 @@ func (e *Engine) ResetSession(token string) {
 	delete(e.sessions, token)
 }
 
+// RuleInfo describes rule idx of token's loaded script for callers (the
+// watch endpoint) that want to report which rule answered a request
+// without reaching into the engine's own locking themselves. pattern is
+// the rule's Match.Pattern, or "METHOD path" if it has none; remaining is
+// the rule's current Times. ok is false if token has no loaded script or
+// idx is out of range.
+func (e *Engine) RuleInfo(token string, idx int) (pattern string, remaining int, ok bool) {
+	session := e.getSession(token)
+	if session == nil {
+		return "", 0, false
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if idx < 0 || idx >= len(session.rules) {
+		return "", 0, false
+	}
+
+	rule := session.rules[idx]
+	pattern = rule.Match.Pattern
+	if pattern == "" {
+		pattern = rule.Match.Method + " " + rule.Match.Path
+	}
+	return pattern, rule.Times, true
+}
+
+// Exhausted reports whether every rule in token's loaded script has been
+// used up (Times == 0; a Times < 0 rule never runs out), meaning the next request against it would fall
+// through to on_unmatched handling. It returns false if token has no
+// loaded script or that script has no rules, since there's nothing to
+// exhaust.
+func (e *Engine) Exhausted(token string) bool {
+	session := e.getSession(token)
+	if session == nil {
+		return false
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if len(session.rules) == 0 {
+		return false
+	}
+	for _, rule := range session.rules {
+		if rule.Times != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// RefundRule restores one use to the rule at idx in token's loaded script.
+// It's for a caller (the server's streaming handlers) that matched a rule
+// via MatchRequestWithIndex but then had the response cancelled mid-stream,
+// so the script's cursor shouldn't advance for a reply the client never
+// fully received. It's a no-op if token has no loaded script or idx doesn't
+// resolve to a rule.
+func (e *Engine) RefundRule(token string, idx int) {
+	session := e.getSession(token)
+	if session == nil {
+		return
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if idx < 0 || idx >= len(session.rules) {
+		return
+	}
+	// Times < 0 is the unlimited sentinel; refunding it is a no-op rather
+	// than ticking it towards (and eventually past) the 0 that would
+	// exhaust an otherwise-unlimited rule.
+	if session.rules[idx].Times >= 0 {
+		session.rules[idx].Times++
+	}
+}
+
 func (e *Engine) GetState(token string) (map[string]interface{}, error) {
 	e.mu.RLock()
 	session, exists := e.sessions[token]
@@ -309,6 +1086,39 @@ func (e *Engine) GetState(token string) (map[string]interface{}, error) {
 	return state, nil
 }
 
+// defaultModels lists the model IDs a token is validated against when it
+// hasn't loaded a script declaring its own Script.Models, matching the
+// chat/completions/embeddings model IDs the rest of the server defaults to.
+var defaultModels = []string{"gpt-4", "gpt-3.5-turbo", "gpt-3.5-turbo-instruct", "text-embedding-ada-002"}
+
+// GetModels returns the model IDs valid for token: the Script.Models it
+// loaded, or defaultModels if it hasn't loaded one or didn't set Models.
+func (e *Engine) GetModels(token string) []string {
+	session := e.getSession(token)
+	if session == nil {
+		return defaultModels
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if len(session.models) == 0 {
+		return defaultModels
+	}
+	return session.models
+}
+
+// ValidateModel reports whether model is one of token's valid models (see
+// GetModels).
+func (e *Engine) ValidateModel(token, model string) bool {
+	for _, m := range e.GetModels(token) {
+		if m == model {
+			return true
+		}
+	}
+	return false
+}
+
 // jsonContains checks if target contains all fields from subset with deep matching
 func jsonContains(target, subset map[string]interface{}) bool {
 	for key, subValue := range subset {
@@ -317,16 +1127,33 @@ func jsonContains(target, subset map[string]interface{}) bool {
 			return false
 		}
 
-		// Handle nested objects
-		if subMap, ok := subValue.(map[string]interface{}); ok {
-			if targetMap, ok := targetValue.(map[string]interface{}); ok {
-				if !jsonContains(targetMap, subMap) {
+		switch subTyped := subValue.(type) {
+		case map[string]interface{}:
+			// Handle nested objects
+			targetMap, ok := targetValue.(map[string]interface{})
+			if !ok || !jsonContains(targetMap, subTyped) {
+				return false
+			}
+		case []interface{}:
+			// Handle nested arrays element-wise, so a rule can match a
+			// messages array (for example) by listing only the fields it
+			// cares about for each entry rather than needing every field
+			// the request actually sends.
+			targetSlice, ok := targetValue.([]interface{})
+			if !ok || len(targetSlice) != len(subTyped) {
+				return false
+			}
+			for i, subItem := range subTyped {
+				if subItemMap, ok := subItem.(map[string]interface{}); ok {
+					targetItemMap, ok := targetSlice[i].(map[string]interface{})
+					if !ok || !jsonContains(targetItemMap, subItemMap) {
+						return false
+					}
+				} else if !reflect.DeepEqual(targetSlice[i], subItem) {
 					return false
 				}
-			} else {
-				return false
 			}
-		} else {
+		default:
 			// Direct value comparison
 			if !reflect.DeepEqual(targetValue, subValue) {
 				return false
@@ -0,0 +1,142 @@
+package script
+
+import "testing"
+
+func boolPtr(b bool) *bool { return &b }
+func intPtr(n int) *int    { return &n }
+
+// TestMatchRuleHasImageGatesOnContentParts verifies a rule with
+// Match.HasImage only fires for a chat completion whose last user message
+// carries at least one image_url content part.
+func TestMatchRuleHasImageGatesOnContentParts(t *testing.T) {
+	engine := NewEngine()
+	token := "image-token"
+
+	script := Script{
+		Reset: true,
+		Rules: []Rule{
+			{
+				Match:    MatchRule{Method: "POST", Path: "/v1/chat/completions", HasImage: boolPtr(true)},
+				Times:    1,
+				Response: ResponseRule{Status: 200, Content: "I see an image"},
+			},
+			{
+				Match:    MatchRule{Method: "POST", Path: "/v1/chat/completions", HasImage: boolPtr(false)},
+				Times:    1,
+				Response: ResponseRule{Status: 200, Content: "No image here"},
+			},
+		},
+	}
+	if err := engine.LoadScript(token, script); err != nil {
+		t.Fatalf("failed to load script: %v", err)
+	}
+
+	imageBody := []byte(`{"messages":[{"role":"user","content":[
+		{"type":"text","text":"what's in this picture?"},
+		{"type":"image_url","image_url":{"url":"https://example.com/cat.png"}}
+	]}]}`)
+	response, err := engine.MatchRequest(token, "POST", "/v1/chat/completions", imageBody)
+	if err != nil {
+		t.Fatalf("failed to match image request: %v", err)
+	}
+	if response.Content != "I see an image" {
+		t.Errorf("expected the image-gated rule, got %q", response.Content)
+	}
+
+	textBody := []byte(`{"messages":[{"role":"user","content":"just text, thanks"}]}`)
+	response, err = engine.MatchRequest(token, "POST", "/v1/chat/completions", textBody)
+	if err != nil {
+		t.Fatalf("failed to match text-only request: %v", err)
+	}
+	if response.Content != "No image here" {
+		t.Errorf("expected the no-image rule, got %q", response.Content)
+	}
+}
+
+// TestMatchRuleImageCountMatchesExactCount verifies Match.ImageCount gates
+// on the exact number of image_url parts, not just their presence.
+func TestMatchRuleImageCountMatchesExactCount(t *testing.T) {
+	engine := NewEngine()
+	token := "image-count-token"
+
+	script := Script{
+		Reset: true,
+		Rules: []Rule{
+			{
+				Match:    MatchRule{Method: "POST", Path: "/v1/chat/completions", ImageCount: intPtr(2)},
+				Times:    1,
+				Response: ResponseRule{Status: 200, Content: "two images"},
+			},
+		},
+	}
+	if err := engine.LoadScript(token, script); err != nil {
+		t.Fatalf("failed to load script: %v", err)
+	}
+
+	oneImage := []byte(`{"messages":[{"role":"user","content":[
+		{"type":"image_url","image_url":{"url":"https://example.com/a.png"}}
+	]}]}`)
+	if _, err := engine.MatchRequest(token, "POST", "/v1/chat/completions", oneImage); err == nil {
+		t.Fatal("expected a single image to not match ImageCount: 2")
+	}
+
+	twoImages := []byte(`{"messages":[{"role":"user","content":[
+		{"type":"image_url","image_url":{"url":"https://example.com/a.png"}},
+		{"type":"image_url","image_url":{"url":"https://example.com/b.png"}}
+	]}]}`)
+	response, err := engine.MatchRequest(token, "POST", "/v1/chat/completions", twoImages)
+	if err != nil {
+		t.Fatalf("failed to match two-image request: %v", err)
+	}
+	if response.Content != "two images" {
+		t.Errorf("expected the two-image rule, got %q", response.Content)
+	}
+}
+
+// TestExtractUserMessagePartsFlattensText verifies the text parts of a
+// multimodal content array are joined for Pattern matching.
+func TestExtractUserMessagePartsFlattensText(t *testing.T) {
+	requestJSON := map[string]interface{}{
+		"messages": []interface{}{
+			map[string]interface{}{
+				"role": "user",
+				"content": []interface{}{
+					map[string]interface{}{"type": "text", "text": "describe"},
+					map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "https://example.com/a.png"}},
+					map[string]interface{}{"type": "text", "text": "this image"},
+				},
+			},
+		},
+	}
+
+	text, imageCount := extractUserMessageParts(requestJSON)
+	if text != "describe this image" {
+		t.Errorf("expected flattened text %q, got %q", "describe this image", text)
+	}
+	if imageCount != 1 {
+		t.Errorf("expected imageCount 1, got %d", imageCount)
+	}
+}
+
+// TestEmbeddingDimsDefaultsAndOverrides verifies a model configured in
+// Script.EmbeddingDims gets its configured dimensionality, and an
+// unconfigured model falls back to defaultEmbeddingDims.
+func TestEmbeddingDimsDefaultsAndOverrides(t *testing.T) {
+	engine := NewEngine()
+	token := "embedding-token"
+
+	script := Script{
+		Reset:         true,
+		EmbeddingDims: map[string]int{"text-embedding-3-small": 256},
+	}
+	if err := engine.LoadScript(token, script); err != nil {
+		t.Fatalf("failed to load script: %v", err)
+	}
+
+	if dims := engine.EmbeddingDims(token, "text-embedding-3-small"); dims != 256 {
+		t.Errorf("expected configured dims 256, got %d", dims)
+	}
+	if dims := engine.EmbeddingDims(token, "text-embedding-ada-002"); dims != defaultEmbeddingDims {
+		t.Errorf("expected default dims %d, got %d", defaultEmbeddingDims, dims)
+	}
+}
@@ -0,0 +1,92 @@
+package script
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimitPolicy caps requests-per-minute and tokens-per-minute for a
+// single model. A zero value for either field means that axis is
+// unmetered, so a script can enforce just RPM, just TPM, or both.
+type RateLimitPolicy struct {
+	RPM int `json:"rpm,omitempty"`
+	TPM int `json:"tpm,omitempty"`
+}
+
+// rateLimitWindow tracks usage within the current one-minute window for a
+// single model. The window resets outright (rather than sliding) once more
+// than a minute has elapsed since windowStart, mirroring how most
+// providers document their per-minute quotas.
+type rateLimitWindow struct {
+	mu           sync.Mutex
+	windowStart  time.Time
+	requestCount int
+	tokenCount   int
+}
+
+// RateLimitResult reports the outcome of a CheckRateLimit call, carrying
+// enough detail for the caller to fill in x-ratelimit-* headers and a
+// Retry-After when Allowed is false.
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	ResetAfter time.Duration
+
+	// Exceeded is "requests" or "tokens", naming which axis of the policy
+	// was exceeded. Meaningless when Allowed is true.
+	Exceeded string
+}
+
+// CheckRateLimit enforces the RateLimitPolicy configured for model (via
+// Script.RateLimits), consuming promptTokens from the window's token
+// budget if the request is allowed. It's a no-op (always Allowed) when the
+// session has no script loaded or no policy for model.
+func (e *Engine) CheckRateLimit(token, model string, promptTokens int) RateLimitResult {
+	session := e.getSession(token)
+	if session == nil {
+		return RateLimitResult{Allowed: true}
+	}
+
+	session.mu.Lock()
+	policy, ok := session.rateLimits[model]
+	if !ok {
+		session.mu.Unlock()
+		return RateLimitResult{Allowed: true}
+	}
+	window, ok := session.rateLimitWindows[model]
+	if !ok {
+		window = &rateLimitWindow{windowStart: time.Now()}
+		session.rateLimitWindows[model] = window
+	}
+	session.mu.Unlock()
+
+	window.mu.Lock()
+	defer window.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(window.windowStart) >= time.Minute {
+		window.windowStart = now
+		window.requestCount = 0
+		window.tokenCount = 0
+	}
+	resetAfter := time.Minute - now.Sub(window.windowStart)
+
+	if policy.RPM > 0 && window.requestCount+1 > policy.RPM {
+		return RateLimitResult{Limit: policy.RPM, Remaining: 0, ResetAfter: resetAfter, Exceeded: "requests"}
+	}
+	if policy.TPM > 0 && window.tokenCount+promptTokens > policy.TPM {
+		return RateLimitResult{Limit: policy.TPM, Remaining: remaining(policy.TPM - window.tokenCount), ResetAfter: resetAfter, Exceeded: "tokens"}
+	}
+
+	window.requestCount++
+	window.tokenCount += promptTokens
+	return RateLimitResult{Allowed: true}
+}
+
+func remaining(n int) int {
+	if n < 0 {
+		return 0
+	}
+	return n
+}
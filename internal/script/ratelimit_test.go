@@ -0,0 +1,103 @@
+package script
+
+import "testing"
+
+// TestCheckRateLimitUnconfiguredModelAlwaysAllowed verifies a model absent
+// from Script.RateLimits is never throttled.
+func TestCheckRateLimitUnconfiguredModelAlwaysAllowed(t *testing.T) {
+	engine := NewEngine()
+	token := "unmetered-token"
+
+	if err := engine.LoadScript(token, Script{Reset: true}); err != nil {
+		t.Fatalf("failed to load script: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if result := engine.CheckRateLimit(token, "gpt-4", 1000); !result.Allowed {
+			t.Fatalf("request %d: expected unmetered model to always be allowed", i)
+		}
+	}
+}
+
+// TestCheckRateLimitEnforcesRPM verifies that once a model's RPM budget is
+// used up within the current window, further requests are rejected with
+// Exceeded "requests" until the window resets.
+func TestCheckRateLimitEnforcesRPM(t *testing.T) {
+	engine := NewEngine()
+	token := "rpm-token"
+
+	script := Script{
+		Reset:      true,
+		RateLimits: map[string]RateLimitPolicy{"gpt-4": {RPM: 2}},
+	}
+	if err := engine.LoadScript(token, script); err != nil {
+		t.Fatalf("failed to load script: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if result := engine.CheckRateLimit(token, "gpt-4", 0); !result.Allowed {
+			t.Fatalf("request %d: expected to be within the RPM budget", i)
+		}
+	}
+
+	result := engine.CheckRateLimit(token, "gpt-4", 0)
+	if result.Allowed {
+		t.Fatal("expected the third request to exceed the RPM budget")
+	}
+	if result.Exceeded != "requests" {
+		t.Errorf("expected Exceeded %q, got %q", "requests", result.Exceeded)
+	}
+}
+
+// TestCheckRateLimitEnforcesTPM verifies that a request whose estimated
+// prompt tokens would push the window over its TPM budget is rejected,
+// even if the RPM budget still has room.
+func TestCheckRateLimitEnforcesTPM(t *testing.T) {
+	engine := NewEngine()
+	token := "tpm-token"
+
+	script := Script{
+		Reset:      true,
+		RateLimits: map[string]RateLimitPolicy{"gpt-4": {RPM: 100, TPM: 100}},
+	}
+	if err := engine.LoadScript(token, script); err != nil {
+		t.Fatalf("failed to load script: %v", err)
+	}
+
+	if result := engine.CheckRateLimit(token, "gpt-4", 60); !result.Allowed {
+		t.Fatal("expected the first 60-token request to be allowed")
+	}
+
+	result := engine.CheckRateLimit(token, "gpt-4", 60)
+	if result.Allowed {
+		t.Fatal("expected the second 60-token request to exceed the 100 TPM budget")
+	}
+	if result.Exceeded != "tokens" {
+		t.Errorf("expected Exceeded %q, got %q", "tokens", result.Exceeded)
+	}
+}
+
+// TestCheckRateLimitPerModelIndependent verifies that a policy configured
+// for one model doesn't throttle requests against a different model.
+func TestCheckRateLimitPerModelIndependent(t *testing.T) {
+	engine := NewEngine()
+	token := "per-model-token"
+
+	script := Script{
+		Reset:      true,
+		RateLimits: map[string]RateLimitPolicy{"gpt-4": {RPM: 1}},
+	}
+	if err := engine.LoadScript(token, script); err != nil {
+		t.Fatalf("failed to load script: %v", err)
+	}
+
+	if result := engine.CheckRateLimit(token, "gpt-4", 0); !result.Allowed {
+		t.Fatal("expected the first gpt-4 request to be allowed")
+	}
+	if result := engine.CheckRateLimit(token, "gpt-4", 0); result.Allowed {
+		t.Fatal("expected the second gpt-4 request to exceed RPM 1")
+	}
+	if result := engine.CheckRateLimit(token, "gpt-3.5-turbo", 0); !result.Allowed {
+		t.Fatal("expected an unrelated model to be unaffected by gpt-4's budget")
+	}
+}
@@ -0,0 +1,117 @@
+package script
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// sessionSnapshot is the JSON-serializable form of one token's
+// SessionState: its loaded rules (Times already reflects the cursor
+// position — how many responses remain) plus the scratchpad state later
+// turns can read back. Transient bookkeeping (conversation turn counts,
+// rate-limit windows, armed cancel channels) is intentionally left out,
+// the same way session.Manager.Restore starts every restored session with
+// a fresh lastAccess rather than replaying idle time.
+type sessionSnapshot struct {
+	Token            string                     `json:"token"`
+	Rules            []Rule                     `json:"rules"`
+	Vars             map[string]string          `json:"vars,omitempty"`
+	RateLimits       map[string]RateLimitPolicy `json:"rate_limits,omitempty"`
+	EmbeddingDims    map[string]int             `json:"embedding_dims,omitempty"`
+	RequestTimeoutMs int                        `json:"timeout_ms,omitempty"`
+	Models           []string                   `json:"models,omitempty"`
+}
+
+// Snapshot writes every token's loaded script, cursor position, and
+// remaining responses to w as a JSON array, so a test suite can freeze
+// engine state between phases and reload it with Restore. Each session's
+// fields are copied under its own lock while the engine's read lock is
+// held just long enough to enumerate sessions, mirroring
+// session.Manager.Snapshot.
+func (e *Engine) Snapshot(w io.Writer) error {
+	entries := e.collectSnapshot()
+
+	encoded, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("script: encoding snapshot: %w", err)
+	}
+
+	_, err = w.Write(encoded)
+	return err
+}
+
+func (e *Engine) collectSnapshot() []sessionSnapshot {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	entries := make([]sessionSnapshot, 0, len(e.sessions))
+	for token, session := range e.sessions {
+		session.mu.Lock()
+		entry := sessionSnapshot{
+			Token:            token,
+			Rules:            append([]Rule(nil), session.rules...),
+			RequestTimeoutMs: session.requestTimeoutMs,
+			Models:           append([]string(nil), session.models...),
+		}
+		if len(session.vars) > 0 {
+			entry.Vars = make(map[string]string, len(session.vars))
+			for k, v := range session.vars {
+				entry.Vars[k] = v
+			}
+		}
+		if len(session.rateLimits) > 0 {
+			entry.RateLimits = make(map[string]RateLimitPolicy, len(session.rateLimits))
+			for k, v := range session.rateLimits {
+				entry.RateLimits[k] = v
+			}
+		}
+		if len(session.embeddingDims) > 0 {
+			entry.EmbeddingDims = make(map[string]int, len(session.embeddingDims))
+			for k, v := range session.embeddingDims {
+				entry.EmbeddingDims[k] = v
+			}
+		}
+		session.mu.Unlock()
+
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Restore replaces the engine's live sessions with the ones decoded from
+// r (as written by Snapshot), atomically: either every token in r is
+// loaded and the previous set of sessions is fully discarded, or (on a
+// decode error) nothing changes. Each restored session starts with fresh
+// rate-limit windows and conversation turn counts, the same way Restore's
+// rules start counting Times down from wherever the snapshot left off
+// rather than from the original script.
+func (e *Engine) Restore(r io.Reader) error {
+	var entries []sessionSnapshot
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return fmt.Errorf("script: decoding snapshot: %w", err)
+	}
+
+	sessions := make(map[string]*SessionState, len(entries))
+	for _, entry := range entries {
+		session := &SessionState{
+			rules:            append([]Rule(nil), entry.Rules...),
+			conversations:    make(map[string]*conversationState),
+			vars:             entry.Vars,
+			rateLimits:       entry.RateLimits,
+			rateLimitWindows: make(map[string]*rateLimitWindow),
+			embeddingDims:    entry.EmbeddingDims,
+			requestTimeoutMs: entry.RequestTimeoutMs,
+			models:           entry.Models,
+		}
+		if session.vars == nil {
+			session.vars = make(map[string]string)
+		}
+		sessions[entry.Token] = session
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sessions = sessions
+	return nil
+}
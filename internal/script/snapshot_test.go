@@ -0,0 +1,93 @@
+package script
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestSnapshotRestoreRoundTripsCursor verifies Restore picks up exactly
+// where Snapshot left off: a rule partially consumed before the snapshot
+// is taken stays at that same remaining count after restore.
+func TestSnapshotRestoreRoundTripsCursor(t *testing.T) {
+	engine := NewEngine()
+	token := "snapshot-token"
+
+	if err := engine.LoadScript(token, Script{
+		Reset: true,
+		Responses: []interface{}{
+			"Dog", "Cat", "Bird",
+		},
+	}); err != nil {
+		t.Fatalf("LoadScript: %v", err)
+	}
+
+	body := []byte(`{"model": "gpt-4", "messages": [{"role": "user", "content": "go"}]}`)
+	if _, _, err := engine.MatchRequestWithIndex(token, "POST", "/v1/chat/completions", body, nil); err != nil {
+		t.Fatalf("first match: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := engine.Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	restored := NewEngine()
+	if err := restored.Restore(&buf); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	response, _, err := restored.MatchRequestWithIndex(token, "POST", "/v1/chat/completions", body, nil)
+	if err != nil {
+		t.Fatalf("match after restore: %v", err)
+	}
+	if response.Content != "Cat" {
+		t.Errorf("got %q, want %q (Dog already consumed before the snapshot)", response.Content, "Cat")
+	}
+}
+
+// TestRestoreReplacesPriorSessions verifies Restore discards whatever
+// tokens the engine had loaded before, not just adds the restored ones.
+func TestRestoreReplacesPriorSessions(t *testing.T) {
+	engine := NewEngine()
+	if err := engine.LoadScript("stale-token", Script{Reset: true, Responses: []interface{}{"stale"}}); err != nil {
+		t.Fatalf("LoadScript: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := engine.Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	if err := engine.LoadScript("fresh-token", Script{Reset: true, Responses: []interface{}{"fresh"}}); err != nil {
+		t.Fatalf("LoadScript: %v", err)
+	}
+
+	if err := engine.Restore(&buf); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	if engine.getSession("fresh-token") != nil {
+		t.Error("expected Restore to discard sessions absent from the snapshot")
+	}
+	if engine.getSession("stale-token") == nil {
+		t.Error("expected the snapshotted token to be restored")
+	}
+}
+
+// TestRestoreInvalidJSONLeavesEngineUnchanged verifies a malformed
+// snapshot document is rejected without disturbing the engine's existing
+// sessions.
+func TestRestoreInvalidJSONLeavesEngineUnchanged(t *testing.T) {
+	engine := NewEngine()
+	if err := engine.LoadScript("keep-token", Script{Reset: true, Responses: []interface{}{"kept"}}); err != nil {
+		t.Fatalf("LoadScript: %v", err)
+	}
+
+	if err := engine.Restore(bytes.NewReader([]byte("not json"))); err == nil {
+		t.Fatal("expected Restore to reject invalid JSON")
+	}
+
+	if engine.getSession("keep-token") == nil {
+		t.Error("expected the existing session to survive a failed restore")
+	}
+}
@@ -0,0 +1,17 @@
+package script
+
+// RequestTimeoutMs returns the request deadline configured for token via
+// Script.TimeoutMs, or 0 if the session has none (or no script is loaded
+// for token at all), meaning the caller should impose no deadline beyond
+// the client's own request context.
+func (e *Engine) RequestTimeoutMs(token string) int {
+	session := e.getSession(token)
+	if session == nil {
+		return 0
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	return session.requestTimeoutMs
+}
@@ -0,0 +1,88 @@
+package script
+
+import "testing"
+
+// TestRequestTimeoutMsDefaultsToZero verifies a script with no TimeoutMs
+// (or no script at all) reports no deadline.
+func TestRequestTimeoutMsDefaultsToZero(t *testing.T) {
+	engine := NewEngine()
+	token := "timeout-default-token"
+
+	if ms := engine.RequestTimeoutMs(token); ms != 0 {
+		t.Errorf("expected 0 for an unloaded token, got %d", ms)
+	}
+
+	if err := engine.LoadScript(token, Script{Reset: true}); err != nil {
+		t.Fatalf("LoadScript: %v", err)
+	}
+	if ms := engine.RequestTimeoutMs(token); ms != 0 {
+		t.Errorf("expected 0 when Script.TimeoutMs is unset, got %d", ms)
+	}
+}
+
+// TestRequestTimeoutMsLoadedFromScript verifies Script.TimeoutMs is stored
+// and returned for the token it was loaded against.
+func TestRequestTimeoutMsLoadedFromScript(t *testing.T) {
+	engine := NewEngine()
+	token := "timeout-configured-token"
+
+	if err := engine.LoadScript(token, Script{Reset: true, TimeoutMs: 250}); err != nil {
+		t.Fatalf("LoadScript: %v", err)
+	}
+
+	if ms := engine.RequestTimeoutMs(token); ms != 250 {
+		t.Errorf("got %d, want 250", ms)
+	}
+}
+
+// TestRefundRuleRestoresTimes verifies RefundRule undoes the Times
+// decrement MatchRequestWithIndex applies when a rule matches.
+func TestRefundRuleRestoresTimes(t *testing.T) {
+	engine := NewEngine()
+	token := "refund-token"
+
+	if err := engine.LoadScript(token, Script{
+		Reset: true,
+		Rules: []Rule{
+			{
+				Match:    MatchRule{Method: "POST", Path: "/v1/chat/completions"},
+				Times:    1,
+				Response: ResponseRule{Status: 200, Content: "hi"},
+			},
+		},
+	}); err != nil {
+		t.Fatalf("LoadScript: %v", err)
+	}
+
+	body := []byte(`{"model": "gpt-4"}`)
+	if _, idx, err := engine.MatchRequestWithIndex(token, "POST", "/v1/chat/completions", body, nil); err != nil || idx != 0 {
+		t.Fatalf("MatchRequestWithIndex: idx=%d err=%v", idx, err)
+	}
+	if _, remaining, _ := engine.RuleInfo(token, 0); remaining != 0 {
+		t.Fatalf("expected Times to be consumed down to 0, got %d", remaining)
+	}
+
+	engine.RefundRule(token, 0)
+
+	if _, remaining, _ := engine.RuleInfo(token, 0); remaining != 1 {
+		t.Errorf("expected RefundRule to restore Times to 1, got %d", remaining)
+	}
+
+	// The rule should be matchable again now that its use was refunded.
+	if _, idx, err := engine.MatchRequestWithIndex(token, "POST", "/v1/chat/completions", body, nil); err != nil || idx != 0 {
+		t.Errorf("expected the refunded rule to match again, got idx=%d err=%v", idx, err)
+	}
+}
+
+// TestRefundRuleIsNoOpForUnknownTokenOrIndex verifies RefundRule doesn't
+// panic when given a token with no loaded script or an out-of-range index.
+func TestRefundRuleIsNoOpForUnknownTokenOrIndex(t *testing.T) {
+	engine := NewEngine()
+	engine.RefundRule("no-such-token", 0)
+
+	token := "refund-oob-token"
+	if err := engine.LoadScript(token, Script{Reset: true}); err != nil {
+		t.Fatalf("LoadScript: %v", err)
+	}
+	engine.RefundRule(token, 5)
+}
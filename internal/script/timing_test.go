@@ -0,0 +1,97 @@
+package script
+
+import (
+	"testing"
+	"time"
+)
+
+// TestMatchRequestArmsAbortDeadline verifies that a matched response
+// carrying Timing.AbortAfterMs arms the session's write-cancel channel so a
+// streaming writer selecting on it later observes the scripted abort.
+func TestMatchRequestArmsAbortDeadline(t *testing.T) {
+	engine := NewEngine()
+	token := "abort-token"
+
+	script := Script{
+		Reset: true,
+		Rules: []Rule{
+			{
+				Match: MatchRule{Method: "POST", Path: "/v1/chat/completions"},
+				Times: 1,
+				Response: ResponseRule{
+					Status:  200,
+					Content: "hello",
+					Timing:  &Timing{AbortAfterMs: 20},
+				},
+			},
+		},
+	}
+	if err := engine.LoadScript(token, script); err != nil {
+		t.Fatalf("failed to load script: %v", err)
+	}
+
+	if _, err := engine.MatchRequest(token, "POST", "/v1/chat/completions", nil); err != nil {
+		t.Fatalf("failed to match request: %v", err)
+	}
+
+	cancelCh := engine.Session(token).WriteCancelCh()
+	if cancelCh == nil {
+		t.Fatal("expected write-cancel channel to be armed")
+	}
+
+	select {
+	case <-cancelCh:
+		t.Fatal("cancel channel closed before the deadline elapsed")
+	case <-time.After(5 * time.Millisecond):
+	}
+
+	select {
+	case <-cancelCh:
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("cancel channel did not close after the deadline elapsed")
+	}
+}
+
+// TestSessionWriteCancelChNilByDefault verifies that sessions without an
+// armed deadline expose a nil channel (which blocks forever in a select),
+// rather than one that fires immediately.
+func TestSessionWriteCancelChNilByDefault(t *testing.T) {
+	engine := NewEngine()
+	token := "no-deadline-token"
+
+	engine.LoadScript(token, Script{Reset: true, Rules: []Rule{
+		{
+			Match:    MatchRule{Method: "POST", Path: "/v1/chat/completions"},
+			Times:    1,
+			Response: ResponseRule{Status: 200, Content: "hi"},
+		},
+	}})
+
+	if _, err := engine.MatchRequest(token, "POST", "/v1/chat/completions", nil); err != nil {
+		t.Fatalf("failed to match request: %v", err)
+	}
+
+	if ch := engine.Session(token).WriteCancelCh(); ch != nil {
+		select {
+		case <-ch:
+			t.Fatal("cancel channel should not fire without an armed deadline")
+		default:
+		}
+	}
+}
+
+// TestArmWriteDeadlineReplacesChannel verifies that re-arming the deadline
+// installs a fresh channel rather than reusing (and potentially confusing
+// readers of) the old one.
+func TestArmWriteDeadlineReplacesChannel(t *testing.T) {
+	session := &SessionState{}
+	session.ArmWriteDeadline(time.Hour)
+	first := session.WriteCancelCh()
+
+	session.ArmWriteDeadline(time.Hour)
+	second := session.WriteCancelCh()
+
+	if first == second {
+		t.Fatal("expected ArmWriteDeadline to install a new channel")
+	}
+}
@@ -0,0 +1,80 @@
+package script
+
+import "testing"
+
+// TestMatchRequestExpandsToolCallCaptures verifies that a tool call's
+// Arguments template has $1, $2, etc. substituted from the matching rule's
+// regex capture groups, without mutating the stored rule.
+func TestMatchRequestExpandsToolCallCaptures(t *testing.T) {
+	engine := NewEngine()
+	token := "tool-token"
+
+	script := Script{
+		Reset: true,
+		Rules: []Rule{
+			{
+				Match: MatchRule{Pattern: "weather in (\\w+)"},
+				Times: 2,
+				Response: ResponseRule{
+					Status: 200,
+					ToolCalls: []ToolCallSpec{
+						{Name: "get_weather", Arguments: `{"location": "$1"}`},
+					},
+				},
+			},
+		},
+	}
+	if err := engine.LoadScript(token, script); err != nil {
+		t.Fatalf("failed to load script: %v", err)
+	}
+
+	body := []byte(`{"messages":[{"role":"user","content":"what's the weather in Boston?"}]}`)
+	response, err := engine.MatchRequest(token, "POST", "/v1/chat/completions", body)
+	if err != nil {
+		t.Fatalf("failed to match request: %v", err)
+	}
+	if got := response.ToolCalls[0].Arguments; got != `{"location": "Boston"}` {
+		t.Errorf("expected captured location to be substituted, got %q", got)
+	}
+
+	// A second match with a different city must not see the first capture
+	// leak in, proving the stored rule itself was left untouched.
+	body2 := []byte(`{"messages":[{"role":"user","content":"what's the weather in Seattle?"}]}`)
+	response2, err := engine.MatchRequest(token, "POST", "/v1/chat/completions", body2)
+	if err != nil {
+		t.Fatalf("failed to match second request: %v", err)
+	}
+	if got := response2.ToolCalls[0].Arguments; got != `{"location": "Seattle"}` {
+		t.Errorf("expected second capture to be substituted independently, got %q", got)
+	}
+}
+
+// TestExtractUserMessagePullsToolResponse verifies that the engine can
+// match on a role:"tool" message's content, for scripts that respond
+// differently once a tool result is reported back.
+func TestExtractUserMessagePullsToolResponse(t *testing.T) {
+	engine := NewEngine()
+	token := "tool-response-token"
+
+	engine.LoadScript(token, Script{Reset: true, Rules: []Rule{
+		{
+			Match:    MatchRule{Pattern: "sunny"},
+			Times:    1,
+			Response: ResponseRule{Status: 200, Content: "Great, bring sunglasses!"},
+		},
+	}})
+
+	body := []byte(`{"messages":[
+		{"role":"user","content":"what's the weather?"},
+		{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{}"}}]},
+		{"role":"tool","tool_call_id":"call_1","content":"sunny, 72F"}
+	]}`)
+
+	response, err := engine.MatchRequest(token, "POST", "/v1/chat/completions", body)
+	if err != nil {
+		t.Fatalf("failed to match on tool response content: %v", err)
+	}
+	if response.Content != "Great, bring sunglasses!" {
+		t.Errorf("expected the tool-response-aware match to win, got %q", response.Content)
+	}
+}
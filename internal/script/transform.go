@@ -0,0 +1,97 @@
+package script
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/fabianvf/llemulator/internal/script/transform"
+)
+
+// transformTimeout bounds how long a single Match.Script/Response.Script
+// run may take before it's aborted and reported as a runtime error.
+const transformTimeout = 50 * time.Millisecond
+
+// defaultScriptLang is used when a rule sets Script but leaves
+// ScriptLang empty.
+const defaultScriptLang = "js"
+
+// compileTransform compiles lang/source once per session and caches the
+// result on session.transformPrograms, so repeated requests against the
+// same rule don't reparse its script every time.
+func (s *SessionState) compileTransform(lang, source string) (transform.Program, error) {
+	if lang == "" {
+		lang = defaultScriptLang
+	}
+
+	key := lang + ":" + source
+	if prog, ok := s.transformPrograms[key]; ok {
+		return prog, nil
+	}
+
+	prog, err := transform.Compile(lang, source)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.transformPrograms == nil {
+		s.transformPrograms = make(map[string]transform.Program)
+	}
+	s.transformPrograms[key] = prog
+	return prog, nil
+}
+
+// runMatchScript evaluates a MatchRule.Script against the current
+// request, returning its boolean match decision.
+func runMatchScript(session *SessionState, lang, source, method, path string, body map[string]interface{}, turn int) (bool, error) {
+	prog, err := session.compileTransform(lang, source)
+	if err != nil {
+		return false, fmt.Errorf("transform match script: %w", err)
+	}
+
+	result, err := prog.Run(transform.Input{
+		Method: method,
+		Path:   path,
+		Body:   body,
+		Turn:   turn,
+		Vars:   session.vars,
+	}, transformTimeout)
+	if err != nil {
+		return false, fmt.Errorf("transform match script: %w", err)
+	}
+	return result.Matched, nil
+}
+
+// runResponseScript evaluates a ResponseRule.Script, synthesizing a
+// ResponseRule from its result in place of the rule's declarative
+// Content/JSON/ContentTemplate.
+func runResponseScript(session *SessionState, lang, source, method, path string, body map[string]interface{}, turn int) (ResponseRule, error) {
+	prog, err := session.compileTransform(lang, source)
+	if err != nil {
+		return ResponseRule{}, fmt.Errorf("transform response script: %w", err)
+	}
+
+	result, err := prog.Run(transform.Input{
+		Method: method,
+		Path:   path,
+		Body:   body,
+		Turn:   turn,
+		Vars:   session.vars,
+	}, transformTimeout)
+	if err != nil {
+		return ResponseRule{}, fmt.Errorf("transform response script: %w", err)
+	}
+
+	response := ResponseRule{Status: result.Status, Content: result.Content}
+	if response.Status == 0 {
+		response.Status = 200
+	}
+	if result.JSON != nil {
+		encoded, err := json.Marshal(result.JSON)
+		if err != nil {
+			return ResponseRule{}, fmt.Errorf("transform response script: encoding json result: %w", err)
+		}
+		response.JSON = encoded
+	}
+	return response, nil
+}
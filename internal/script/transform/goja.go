@@ -0,0 +1,92 @@
+//go:build goja
+
+package transform
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+func init() {
+	Registry["js"] = jsEngine{}
+}
+
+// jsEngine compiles JavaScript transform scripts with goja, a pure-Go
+// ECMAScript interpreter — no cgo, no host filesystem/network bindings
+// are ever registered, so a script's only surface is the "request"
+// global it's handed plus whatever ECMAScript builtins ship with goja.
+type jsEngine struct{}
+
+func (jsEngine) Compile(source string) (Program, error) {
+	prog, err := goja.Compile("transform.js", source, false)
+	if err != nil {
+		return nil, fmt.Errorf("transform: compiling js: %w", err)
+	}
+	return &jsProgram{prog: prog}, nil
+}
+
+type jsProgram struct {
+	prog *goja.Program
+}
+
+func (p *jsProgram) Run(input Input, timeout time.Duration) (result Result, err error) {
+	vm := goja.New()
+	vm.Set("request", map[string]interface{}{
+		"method":  input.Method,
+		"path":    input.Path,
+		"headers": input.Headers,
+		"body":    input.Body,
+		"turn":    input.Turn,
+		"vars":    input.Vars,
+	})
+
+	timer := time.AfterFunc(timeout, func() {
+		vm.Interrupt("transform: script timed out")
+	})
+	defer timer.Stop()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("transform: js runtime error: %v", r)
+		}
+	}()
+
+	value, runErr := vm.RunProgram(p.prog)
+	if runErr != nil {
+		return Result{}, fmt.Errorf("transform: running js: %w", runErr)
+	}
+
+	return decodeResult(value.Export())
+}
+
+// decodeResult accepts either a plain boolean (a MatchRule.Script match
+// decision) or an object with matched/status/content/json fields (a
+// ResponseRule.Script's synthesized response).
+func decodeResult(exported interface{}) (Result, error) {
+	switch v := exported.(type) {
+	case bool:
+		return Result{Matched: v}, nil
+	case map[string]interface{}:
+		result := Result{HasResponse: true, Matched: true}
+		if matched, ok := v["matched"].(bool); ok {
+			result.Matched = matched
+		}
+		switch status := v["status"].(type) {
+		case int64:
+			result.Status = int(status)
+		case float64:
+			result.Status = int(status)
+		}
+		if content, ok := v["content"].(string); ok {
+			result.Content = content
+		}
+		if body, ok := v["json"].(map[string]interface{}); ok {
+			result.JSON = body
+		}
+		return result, nil
+	default:
+		return Result{}, fmt.Errorf("transform: script must return a bool or object, got %T", exported)
+	}
+}
@@ -0,0 +1,165 @@
+//go:build gopherlua
+
+package transform
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	luaparse "github.com/yuin/gopher-lua/parse"
+)
+
+func init() {
+	Registry["lua"] = luaEngine{}
+}
+
+// luaEngine compiles Lua transform scripts with gopher-lua, a pure-Go
+// Lua VM. Each Run opens a fresh state with only the base library loaded
+// — io/os are never opened, so scripts have no filesystem or network
+// access — and bounds execution with a context deadline.
+type luaEngine struct{}
+
+func (luaEngine) Compile(source string) (Program, error) {
+	chunk, err := luaparse.Parse(strings.NewReader(source), "transform.lua")
+	if err != nil {
+		return nil, fmt.Errorf("transform: parsing lua: %w", err)
+	}
+	proto, err := lua.Compile(chunk, "transform.lua")
+	if err != nil {
+		return nil, fmt.Errorf("transform: compiling lua: %w", err)
+	}
+	return &luaProgram{proto: proto}, nil
+}
+
+type luaProgram struct {
+	proto *lua.FunctionProto
+}
+
+func (p *luaProgram) Run(input Input, timeout time.Duration) (result Result, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	defer L.Close()
+	L.SetContext(ctx)
+
+	if err := L.CallByParam(lua.P{Fn: L.NewFunction(lua.OpenBase), NRet: 0, Protect: true}); err != nil {
+		return Result{}, fmt.Errorf("transform: opening lua base library: %w", err)
+	}
+
+	L.SetGlobal("request", toLuaValue(L, map[string]interface{}{
+		"method":  input.Method,
+		"path":    input.Path,
+		"headers": input.Headers,
+		"body":    input.Body,
+		"turn":    input.Turn,
+		"vars":    input.Vars,
+	}))
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("transform: lua runtime error: %v", r)
+		}
+	}()
+
+	lfunc := L.NewFunctionFromProto(p.proto)
+	L.Push(lfunc)
+	if err := L.PCall(0, 1, nil); err != nil {
+		return Result{}, fmt.Errorf("transform: running lua: %w", err)
+	}
+
+	ret := L.Get(-1)
+	L.Pop(1)
+	return decodeLuaResult(ret)
+}
+
+// toLuaValue converts a Go value built from map[string]interface{},
+// []interface{}, string, int, float64, and bool into the equivalent Lua
+// value, recursively.
+func toLuaValue(L *lua.LState, v interface{}) lua.LValue {
+	switch val := v.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(val)
+	case string:
+		return lua.LString(val)
+	case int:
+		return lua.LNumber(val)
+	case float64:
+		return lua.LNumber(val)
+	case map[string]string:
+		table := L.NewTable()
+		for k, item := range val {
+			table.RawSetString(k, lua.LString(item))
+		}
+		return table
+	case map[string]interface{}:
+		table := L.NewTable()
+		for k, item := range val {
+			table.RawSetString(k, toLuaValue(L, item))
+		}
+		return table
+	case []interface{}:
+		table := L.NewTable()
+		for _, item := range val {
+			table.Append(toLuaValue(L, item))
+		}
+		return table
+	default:
+		return lua.LNil
+	}
+}
+
+// decodeLuaResult accepts either a plain boolean (a MatchRule.Script
+// match decision) or a table with matched/status/content/json fields (a
+// ResponseRule.Script's synthesized response).
+func decodeLuaResult(v lua.LValue) (Result, error) {
+	switch val := v.(type) {
+	case lua.LBool:
+		return Result{Matched: bool(val)}, nil
+	case *lua.LTable:
+		result := Result{HasResponse: true, Matched: true}
+		if matched, ok := val.RawGetString("matched").(lua.LBool); ok {
+			result.Matched = bool(matched)
+		}
+		if status, ok := val.RawGetString("status").(lua.LNumber); ok {
+			result.Status = int(status)
+		}
+		if content, ok := val.RawGetString("content").(lua.LString); ok {
+			result.Content = string(content)
+		}
+		if body, ok := val.RawGetString("json").(*lua.LTable); ok {
+			result.JSON = fromLuaTable(body)
+		}
+		return result, nil
+	default:
+		return Result{}, fmt.Errorf("transform: script must return a bool or table, got %s", v.Type().String())
+	}
+}
+
+// fromLuaTable converts a Lua table with string keys back into a Go
+// map[string]interface{}, for a ResponseRule.Script's "json" field.
+func fromLuaTable(table *lua.LTable) map[string]interface{} {
+	out := make(map[string]interface{})
+	table.ForEach(func(key, value lua.LValue) {
+		k, ok := key.(lua.LString)
+		if !ok {
+			return
+		}
+		switch v := value.(type) {
+		case lua.LString:
+			out[string(k)] = string(v)
+		case lua.LNumber:
+			out[string(k)] = float64(v)
+		case lua.LBool:
+			out[string(k)] = bool(v)
+		case *lua.LTable:
+			out[string(k)] = fromLuaTable(v)
+		}
+	})
+	return out
+}
@@ -0,0 +1,83 @@
+//go:build gopherlua
+
+package transform
+
+import (
+	"testing"
+	"time"
+)
+
+// TestLuaEngineMatchScript drives the registered "lua" Engine (the actual
+// gopher-lua-backed implementation, not fakeTransformEngine from the
+// script package's tests) through a boolean match decision.
+func TestLuaEngineMatchScript(t *testing.T) {
+	engine, ok := Registry["lua"]
+	if !ok {
+		t.Fatal(`Registry["lua"] not populated; lua.go's init() didn't run`)
+	}
+
+	prog, err := engine.Compile(`return request.turn % 2 == 0`)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	result, err := prog.Run(Input{Turn: 1}, time.Second)
+	if err != nil {
+		t.Fatalf("Run(turn=1): %v", err)
+	}
+	if result.Matched {
+		t.Error("turn 1 should not match turn %% 2 == 0")
+	}
+
+	result, err = prog.Run(Input{Turn: 2}, time.Second)
+	if err != nil {
+		t.Fatalf("Run(turn=2): %v", err)
+	}
+	if !result.Matched {
+		t.Error("turn 2 should match turn %% 2 == 0")
+	}
+}
+
+// TestLuaEngineSynthesizesResponse verifies a table return populates
+// Result's response fields, with request.vars/request.turn readable from
+// the script.
+func TestLuaEngineSynthesizesResponse(t *testing.T) {
+	engine := Registry["lua"]
+
+	prog, err := engine.Compile(`
+		return {
+			status = 201,
+			content = "hi " .. request.vars.name,
+			json = {turn = request.turn},
+		}
+	`)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	result, err := prog.Run(Input{Turn: 3, Vars: map[string]string{"name": "script"}}, time.Second)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !result.HasResponse || result.Status != 201 || result.Content != "hi script" {
+		t.Errorf("result = %+v; want HasResponse, status 201, content \"hi script\"", result)
+	}
+	if result.JSON["turn"] != float64(3) {
+		t.Errorf("result.JSON[turn] = %v, want 3", result.JSON["turn"])
+	}
+}
+
+// TestLuaEngineTimesOut verifies a script that never returns is aborted by
+// the caller-supplied timeout rather than hanging the request.
+func TestLuaEngineTimesOut(t *testing.T) {
+	engine := Registry["lua"]
+
+	prog, err := engine.Compile(`while true do end`)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	if _, err := prog.Run(Input{}, 10*time.Millisecond); err == nil {
+		t.Error("expected an infinite loop to time out, got nil error")
+	}
+}
@@ -0,0 +1,81 @@
+// Package transform lets a script Rule's match decision or response be
+// computed by a user-supplied snippet instead of purely declarative
+// fields, for logic too dynamic to express as JSON (rolling token counts,
+// conditional tool-call shapes, and the like).
+//
+// The package itself only defines the Engine/Program contract and a
+// Registry language implementations register themselves into — it has no
+// dependency on any scripting runtime. goja.go and lua.go provide the
+// actual JS and Lua engines, each gated behind its own build tag so a
+// binary that doesn't need one language doesn't pay for its dependency:
+// build with `-tags goja` for Registry["js"] and `-tags gopherlua` for
+// Registry["lua"]. Neither is included in a default `go build`/`go test`,
+// so both tags also need to be passed together (e.g. `-tags "goja
+// gopherlua"`) to exercise a script that can be loaded in either language.
+// See also the module's other optional build tags: `-tags websocket`
+// (internal/server/ws.go) and `-tags redis` (internal/session/store_redis.go).
+package transform
+
+import (
+	"errors"
+	"time"
+)
+
+// Input is everything a transform script can see about the current
+// request and session.
+type Input struct {
+	Method  string
+	Path    string
+	Headers map[string]string
+	Body    map[string]interface{}
+	Turn    int
+	Vars    map[string]string
+}
+
+// Result is what a transform script hands back. Matched is the boolean
+// match decision for a MatchRule.Script. HasResponse, Status, Content,
+// and JSON are populated from a ResponseRule.Script's return value and
+// together replace the rule's own declarative response fields.
+type Result struct {
+	Matched     bool
+	HasResponse bool
+	Status      int
+	Content     string
+	JSON        map[string]interface{}
+}
+
+// Program is a single compiled script snippet, reusable across every
+// request the owning rule answers so the cost of parsing is paid once
+// per distinct script rather than per request.
+type Program interface {
+	// Run executes the program against input, aborting with an error if
+	// it doesn't finish within timeout. Implementations never grant
+	// scripts filesystem or network access.
+	Run(input Input, timeout time.Duration) (Result, error)
+}
+
+// Engine compiles script source for one language into a reusable
+// Program.
+type Engine interface {
+	Compile(source string) (Program, error)
+}
+
+// ErrUnsupportedLang is returned by Compile when no Engine is registered
+// for the requested language, typically because the binary was built
+// without that language's build tag.
+var ErrUnsupportedLang = errors.New("transform: unsupported script language")
+
+// Registry maps a ScriptLang value ("js", "lua") to the Engine that
+// compiles it. Each language's build-tagged file populates its own entry
+// from an init(), so omitting a build tag leaves that language simply
+// absent rather than causing a link failure.
+var Registry = map[string]Engine{}
+
+// Compile looks up lang in Registry and compiles source with it.
+func Compile(lang, source string) (Program, error) {
+	engine, ok := Registry[lang]
+	if !ok {
+		return nil, ErrUnsupportedLang
+	}
+	return engine.Compile(source)
+}
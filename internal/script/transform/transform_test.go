@@ -0,0 +1,13 @@
+package transform
+
+import "testing"
+
+// TestCompileUnsupportedLangReturnsErrUnsupportedLang verifies a language
+// with no registered Engine (e.g. a binary built without that language's
+// tag) fails clearly rather than panicking.
+func TestCompileUnsupportedLangReturnsErrUnsupportedLang(t *testing.T) {
+	_, err := Compile("cobol", "whatever")
+	if err != ErrUnsupportedLang {
+		t.Errorf("Compile(unregistered lang) = %v, want ErrUnsupportedLang", err)
+	}
+}
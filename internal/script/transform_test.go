@@ -0,0 +1,166 @@
+package script
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fabianvf/llemulator/internal/script/transform"
+)
+
+// fakeTransformEngine is a minimal transform.Engine registered under the
+// "fake" language so these tests can exercise the script package's
+// wiring (compile caching, match gating, response synthesis, error
+// propagation) without depending on goja or gopher-lua.
+type fakeTransformEngine struct {
+	compiles int
+}
+
+func (f *fakeTransformEngine) Compile(source string) (transform.Program, error) {
+	f.compiles++
+	switch source {
+	case "error":
+		return nil, fmt.Errorf("fake: bad script")
+	default:
+		return fakeProgram(source), nil
+	}
+}
+
+type fakeProgram string
+
+func (p fakeProgram) Run(input transform.Input, timeout time.Duration) (transform.Result, error) {
+	switch string(p) {
+	case "match-even-turns":
+		return transform.Result{Matched: input.Turn%2 == 0}, nil
+	case "runtime-error":
+		return transform.Result{}, fmt.Errorf("fake: runtime failure")
+	case "synth-response":
+		return transform.Result{
+			HasResponse: true,
+			Matched:     true,
+			Status:      201,
+			Content:     "from script",
+			JSON:        map[string]interface{}{"turn": float64(input.Turn)},
+		}, nil
+	default:
+		return transform.Result{Matched: true}, nil
+	}
+}
+
+func TestMatchScriptGatesOnScriptResult(t *testing.T) {
+	fake := &fakeTransformEngine{}
+	transform.Registry["fake"] = fake
+	defer delete(transform.Registry, "fake")
+
+	engine := NewEngine()
+	token := "match-script-token"
+	err := engine.LoadScript(token, Script{
+		Reset: true,
+		Rules: []Rule{{
+			Match:    MatchRule{Method: "POST", Path: "/v1/chat/completions", Script: "match-even-turns", ScriptLang: "fake"},
+			Times:    10,
+			Response: ResponseRule{Status: 200, Content: "ok"},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("failed to load script: %v", err)
+	}
+
+	body := []byte(`{"messages":[{"role":"user","content":"hi"}]}`)
+
+	// Turn 1 (odd) should not match; turn 2 (even) should.
+	if _, err := engine.MatchRequest(token, "POST", "/v1/chat/completions", body); err == nil {
+		t.Error("expected turn 1 to be rejected by the match script")
+	}
+	if _, err := engine.MatchRequest(token, "POST", "/v1/chat/completions", body); err != nil {
+		t.Errorf("expected turn 2 to be accepted by the match script, got error: %v", err)
+	}
+}
+
+func TestCompileTransformCachesCompiledProgram(t *testing.T) {
+	fake := &fakeTransformEngine{}
+	transform.Registry["fake"] = fake
+	defer delete(transform.Registry, "fake")
+
+	engine := NewEngine()
+	token := "cache-token"
+	if err := engine.LoadScript(token, Script{
+		Reset: true,
+		Rules: []Rule{{
+			Match:    MatchRule{Method: "GET", Path: "/v1/ping", Script: "always-match", ScriptLang: "fake"},
+			Times:    5,
+			Response: ResponseRule{Status: 200, Content: "pong"},
+		}},
+	}); err != nil {
+		t.Fatalf("failed to load script: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := engine.MatchRequest(token, "GET", "/v1/ping", nil); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+
+	if fake.compiles != 1 {
+		t.Errorf("compiles = %d, want 1 (cached across requests)", fake.compiles)
+	}
+}
+
+func TestResponseScriptSynthesizesResponse(t *testing.T) {
+	fake := &fakeTransformEngine{}
+	transform.Registry["fake"] = fake
+	defer delete(transform.Registry, "fake")
+
+	engine := NewEngine()
+	token := "response-script-token"
+	if err := engine.LoadScript(token, Script{
+		Reset: true,
+		Rules: []Rule{{
+			Match:    MatchRule{Method: "POST", Path: "/v1/chat/completions"},
+			Times:    1,
+			Response: ResponseRule{Script: "synth-response", ScriptLang: "fake"},
+		}},
+	}); err != nil {
+		t.Fatalf("failed to load script: %v", err)
+	}
+
+	response, err := engine.MatchRequest(token, "POST", "/v1/chat/completions", []byte(`{}`))
+	if err != nil {
+		t.Fatalf("MatchRequest: %v", err)
+	}
+	if response.Status != 201 || response.Content != "from script" {
+		t.Errorf("response = %+v; want status 201, content \"from script\"", response)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(response.JSON, &decoded); err != nil {
+		t.Fatalf("unmarshal response.JSON: %v", err)
+	}
+	if decoded["turn"] != float64(1) {
+		t.Errorf("response.JSON[\"turn\"] = %v, want 1", decoded["turn"])
+	}
+}
+
+func TestMatchScriptRuntimeErrorPropagates(t *testing.T) {
+	fake := &fakeTransformEngine{}
+	transform.Registry["fake"] = fake
+	defer delete(transform.Registry, "fake")
+
+	engine := NewEngine()
+	token := "runtime-error-token"
+	if err := engine.LoadScript(token, Script{
+		Reset: true,
+		Rules: []Rule{{
+			Match:    MatchRule{Method: "POST", Path: "/v1/chat/completions", Script: "runtime-error", ScriptLang: "fake"},
+			Times:    1,
+			Response: ResponseRule{Status: 200},
+		}},
+	}); err != nil {
+		t.Fatalf("failed to load script: %v", err)
+	}
+
+	if _, err := engine.MatchRequest(token, "POST", "/v1/chat/completions", []byte(`{}`)); err == nil {
+		t.Error("expected a script runtime error to propagate as a MatchRequest error")
+	}
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabianvf/llemulator/internal/models"
+)
+
+// TestWriteChatCompletionStreamCancellation verifies that cancelling the
+// context mid-stream stops delivery, reports it in the returned
+// StreamResult, emits a "cancelled" finish chunk instead of finishing
+// normally, and never writes [DONE].
+func TestWriteChatCompletionStreamCancellation(t *testing.T) {
+	server := NewServer()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(25*time.Millisecond, cancel)
+
+	content := strings.Repeat("word ", 50)
+	req := map[string]interface{}{"model": "gpt-4", "stream": true}
+
+	recorder := httptest.NewRecorder()
+	result := server.writeChatCompletionStream(ctx, recorder, "", content, nil, req, nil)
+
+	if !result.Cancelled {
+		t.Fatal("expected StreamResult.Cancelled to be true")
+	}
+	if result.CancelCause != context.Canceled {
+		t.Errorf("expected CancelCause to be context.Canceled, got %v", result.CancelCause)
+	}
+	if result.Delivered >= 50 {
+		t.Errorf("expected cancellation to cut delivery short, got %d of 50 chunks", result.Delivered)
+	}
+
+	body := recorder.Body.String()
+	if strings.Contains(body, "[DONE]") {
+		t.Error("a cancelled stream must never emit [DONE]")
+	}
+	if !strings.Contains(body, `"finish_reason":"cancelled"`) {
+		t.Error("expected a finish chunk with finish_reason cancelled")
+	}
+
+	// Count delivered content chunks in the body and make sure it matches
+	// what the caller was told was delivered.
+	delivered := 0
+	for _, line := range strings.Split(body, "\n") {
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var chunk models.ChatCompletion
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &chunk); err != nil {
+			continue
+		}
+		if chunk.Choices[0].Delta != nil && chunk.Choices[0].Delta.Content != "" {
+			delivered++
+		}
+	}
+	if delivered != result.Delivered {
+		t.Errorf("StreamResult.Delivered (%d) does not match chunks actually written (%d)", result.Delivered, delivered)
+	}
+}
+
+// TestStreamCancellationViaHTTPClient drives a real client that cancels its
+// request context mid-stream and verifies the server notices and stops
+// promptly rather than continuing to write to an abandoned connection.
+func TestStreamCancellationViaHTTPClient(t *testing.T) {
+	srv := NewServer()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	token := "cancel-test"
+	scriptBody, _ := json.Marshal(map[string]interface{}{
+		"reset":     true,
+		"responses": strings.Repeat("word ", 200),
+	})
+	scriptReq, _ := http.NewRequest("POST", ts.URL+"/_emulator/script", bytes.NewReader(scriptBody))
+	scriptReq.Header.Set("Authorization", "Bearer "+token)
+	if _, err := http.DefaultClient.Do(scriptReq); err != nil {
+		t.Fatalf("failed to load script: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	chatBody, _ := json.Marshal(map[string]interface{}{
+		"model":  "gpt-4",
+		"stream": true,
+		"messages": []map[string]interface{}{
+			{"role": "user", "content": "go"},
+		},
+	})
+	chatReq, _ := http.NewRequestWithContext(ctx, "POST", ts.URL+"/v1/chat/completions", bytes.NewReader(chatBody))
+	chatReq.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(chatReq)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Read a little, then cancel and make sure the read unblocks promptly.
+	buf := make([]byte, 256)
+	resp.Body.Read(buf)
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			if _, err := resp.Body.Read(buf); err != nil {
+				close(done)
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("client read did not unblock within a bound after context cancellation")
+	}
+}
+
+// TestCancelledStreamRefundsRuleForNextRequest verifies that a client
+// disconnecting mid-stream doesn't advance the script's cursor: the rule it
+// matched is refunded, so a subsequent (uncancelled) request against the
+// same token sees the same script entry rather than the next one.
+func TestCancelledStreamRefundsRuleForNextRequest(t *testing.T) {
+	srv := NewServer()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	token := "cancel-refund-test"
+	scriptBody, _ := json.Marshal(map[string]interface{}{
+		"reset": true,
+		"responses": []interface{}{
+			strings.Repeat("first ", 20),
+			"second",
+		},
+	})
+	scriptReq, _ := http.NewRequest("POST", ts.URL+"/_emulator/script", bytes.NewReader(scriptBody))
+	scriptReq.Header.Set("Authorization", "Bearer "+token)
+	if _, err := http.DefaultClient.Do(scriptReq); err != nil {
+		t.Fatalf("failed to load script: %v", err)
+	}
+
+	chatBody := func() []byte {
+		b, _ := json.Marshal(map[string]interface{}{
+			"model":  "gpt-4",
+			"stream": true,
+			"messages": []map[string]interface{}{
+				{"role": "user", "content": "go"},
+			},
+		})
+		return b
+	}
+
+	// First request: read a couple of chunks, then cancel.
+	ctx, cancel := context.WithCancel(context.Background())
+	firstReq, _ := http.NewRequestWithContext(ctx, "POST", ts.URL+"/v1/chat/completions", bytes.NewReader(chatBody()))
+	firstReq.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(firstReq)
+	if err != nil {
+		t.Fatalf("first request failed: %v", err)
+	}
+	buf := make([]byte, 64)
+	resp.Body.Read(buf)
+	resp.Body.Read(buf)
+	cancel()
+	resp.Body.Close()
+
+	// Give the server's streaming goroutine a moment to notice ctx.Done()
+	// and refund the rule before the second request races it.
+	time.Sleep(50 * time.Millisecond)
+
+	// Second request: not cancelled, should still see the first rule
+	// ("first ...") rather than "second", since the first use was refunded.
+	secondReq, _ := http.NewRequest("POST", ts.URL+"/v1/chat/completions", bytes.NewReader(chatBody()))
+	secondReq.Header.Set("Authorization", "Bearer "+token)
+	secondResp, err := http.DefaultClient.Do(secondReq)
+	if err != nil {
+		t.Fatalf("second request failed: %v", err)
+	}
+	defer secondResp.Body.Close()
+
+	body, err := io.ReadAll(secondResp.Body)
+	if err != nil {
+		t.Fatalf("failed to read second response: %v", err)
+	}
+	if !strings.Contains(string(body), `"first`) {
+		t.Errorf("expected the refunded rule to answer the next request, got: %s", body)
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/fabianvf/llemulator/internal/models"
+)
+
+// generateChunks produces the transport-agnostic sequence of chat completion
+// chunks for content: a role-only chunk followed by one chunk per word. It
+// is shared by both the SSE and WebSocket writers so the two transports stay
+// in lockstep. The channel is closed once all chunks have been sent or ctx
+// is done; a consumer that stops reading (e.g. because its connection died)
+// lets the producer goroutine exit via the ctx.Done() case rather than
+// blocking forever on a full channel.
+func generateChunks(ctx context.Context, id, model, content string) <-chan models.ChatCompletion {
+	ch := make(chan models.ChatCompletion)
+
+	go func() {
+		defer close(ch)
+
+		send := func(chunk models.ChatCompletion) bool {
+			select {
+			case ch <- chunk:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		if !send(models.ChatCompletion{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: time.Now().Unix(),
+			Model:   model,
+			Choices: []models.ChatChoice{
+				{
+					Index: 0,
+					Delta: &models.ChatMessage{Role: "assistant"},
+				},
+			},
+		}) {
+			return
+		}
+
+		words := strings.Fields(content)
+		for i, word := range words {
+			text := word
+			if i < len(words)-1 {
+				text += " "
+			}
+
+			if !send(models.ChatCompletion{
+				ID:      id,
+				Object:  "chat.completion.chunk",
+				Created: time.Now().Unix(),
+				Model:   model,
+				Choices: []models.ChatChoice{
+					{
+						Index: 0,
+						Delta: &models.ChatMessage{Content: text},
+					},
+				},
+			}) {
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// splitIntoFragments breaks s into chunks of at most size runes, for
+// streaming a tool call's JSON arguments incrementally the way a real
+// model would emit them token-by-token, rather than as a single fragment.
+func splitIntoFragments(s string, size int) []string {
+	if s == "" {
+		return nil
+	}
+	runes := []rune(s)
+	fragments := make([]string, 0, (len(runes)+size-1)/size)
+	for i := 0; i < len(runes); i += size {
+		end := i + size
+		if end > len(runes) {
+			end = len(runes)
+		}
+		fragments = append(fragments, string(runes[i:end]))
+	}
+	return fragments
+}
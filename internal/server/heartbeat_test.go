@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabianvf/llemulator/internal/script"
+)
+
+// TestHeartbeatKeepAlive verifies that idle gaps between events longer than
+// the configured heartbeat interval produce ": keep-alive" comment lines.
+func TestHeartbeatKeepAlive(t *testing.T) {
+	server := NewServer(WithHeartbeatInterval(5 * time.Millisecond))
+
+	events := make([]script.SSEEvent, 20)
+	for i := range events {
+		events[i] = script.SSEEvent{Data: json.RawMessage(`{"chunk": 1}`)}
+	}
+
+	recorder := httptest.NewRecorder()
+	server.streamSSEResponse(recorder, events)
+
+	body := recorder.Body.String()
+	if !strings.Contains(body, ": keep-alive\n\n") {
+		t.Error("expected at least one keep-alive comment for a stream slower than the heartbeat interval")
+	}
+}
+
+// TestHeartbeatNotEmittedMidEvent verifies heartbeat writes never split a
+// data frame: every non-blank line is either a full "data: ..." frame or a
+// full ": keep-alive" comment, never a fragment of one.
+func TestHeartbeatNotEmittedMidEvent(t *testing.T) {
+	server := NewServer(WithHeartbeatInterval(5 * time.Millisecond))
+
+	events := []script.SSEEvent{
+		{Data: json.RawMessage(`{"chunk": 1}`)},
+		{Data: json.RawMessage(`{"chunk": 2}`)},
+		{Data: json.RawMessage(`"[DONE]"`)},
+	}
+
+	recorder := httptest.NewRecorder()
+	server.streamSSEResponse(recorder, events)
+
+	body := recorder.Body.String()
+	for _, line := range strings.Split(body, "\n") {
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "data: ") && !strings.HasPrefix(line, ": keep-alive") {
+			t.Errorf("malformed or interleaved SSE line: %q", line)
+		}
+	}
+}
+
+// TestHeartbeatStopsOnContextDone verifies the heartbeat goroutine and the
+// event loop both exit promptly once the request context is cancelled.
+func TestHeartbeatStopsOnContextDone(t *testing.T) {
+	server := NewServer(WithHeartbeatInterval(5 * time.Millisecond))
+
+	events := make([]script.SSEEvent, 50)
+	for i := range events {
+		events[i] = script.SSEEvent{Data: json.RawMessage(`{"chunk": 1}`)}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	recorder := httptest.NewRecorder()
+
+	doneCh := make(chan struct{})
+	go func() {
+		server.streamSSEResponseContext(ctx, recorder, events)
+		close(doneCh)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatal("streamSSEResponseContext did not exit within a bound after context cancellation")
+	}
+
+	eventLines := strings.Count(recorder.Body.String(), "data: ")
+	if eventLines >= len(events) {
+		t.Errorf("expected cancellation to cut the stream short, got all %d events", eventLines)
+	}
+}
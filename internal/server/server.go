@@ -1,30 +1,113 @@
 package server
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	mathrand "math/rand"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/fabianvf/llemulator/internal/audit"
 	"github.com/fabianvf/llemulator/internal/models"
 	"github.com/fabianvf/llemulator/internal/script"
+	"github.com/fabianvf/llemulator/internal/session"
 )
 
+// maxTranscriptionUpload bounds how much of a multipart audio upload
+// ParseMultipartForm buffers in memory; the emulator only needs the
+// uploaded file's name and size, never its contents.
+const maxTranscriptionUpload = 32 << 20
+
 type Server struct {
-	engine *script.Engine
-	debug  bool
+	engine            *script.Engine
+	debug             bool
+	heartbeatInterval time.Duration
+
+	// sessions is separate persistence/eviction infrastructure from the
+	// engine's own per-token rule state. Its per-token Session is handed
+	// to the engine on every request so Match.SessionMatch/
+	// Response.SessionUpdate rules can gate on and mutate it; it defaults
+	// to an in-memory store.
+	sessions *session.Manager
+
+	// watch records the per-token lifecycle events (script loaded, a rule
+	// consumed, the script exhausted, an error returned) that GET
+	// /_emulator/watch streams to integration tests.
+	watch *watchHub
+
+	// auditLog records every request HandleOpenAIRequest handles, scripted
+	// or recorded, for GET /_emulator/audit and /_emulator/audit/har.
+	auditLog *audit.Log
+
+	// recorder proxies /v1/* to upstreamBaseURL and captures the real
+	// traffic into auditLog while active, toggled by
+	// /_emulator/record/start and /stop.
+	recorder        *audit.Recorder
+	upstreamBaseURL string
+
+	// adminToken, if set via the EMULATOR_ADMIN_TOKEN env var, gates POST
+	// /_emulator/restore the same way every other endpoint gates on
+	// extractToken: the request's Authorization bearer must equal it.
+	// Empty (the default) leaves restore open, for local test suites that
+	// have no reason to guard it.
+	adminToken string
+
+	// draining flips HandleReadyz to 503 as soon as the process starts
+	// shutting down, so a load balancer stops routing new traffic during
+	// the grace window cmd/openai-emulator's main.go gives in-flight
+	// requests to finish. See SetDraining.
+	drainingMu sync.RWMutex
+	draining   bool
+}
+
+// ServerOption configures optional Server behavior.
+type ServerOption func(*Server)
+
+// WithHeartbeatInterval overrides how often idle SSE streams receive a
+// ": keep-alive" comment frame. The default is defaultHeartbeatInterval.
+func WithHeartbeatInterval(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.heartbeatInterval = d
+	}
+}
+
+// WithSessionStore selects the backend used to persist session state,
+// e.g. a session.FileStore for on-disk persistence or a session.RedisStore
+// so multiple replicas behind a load balancer share state. Defaults to an
+// in-memory store.
+func WithSessionStore(store session.SessionStore, opts session.ManagerOptions) ServerOption {
+	return func(s *Server) {
+		s.sessions = session.NewManagerWithStore(store, opts)
+	}
 }
 
-func NewServer() *Server {
-	return &Server{
-		engine: script.NewEngine(),
-		debug:  os.Getenv("DEBUG") == "true",
+func NewServer(opts ...ServerOption) *Server {
+	auditLog := audit.NewLog()
+	s := &Server{
+		engine:            script.NewEngine(),
+		debug:             os.Getenv("DEBUG") == "true",
+		heartbeatInterval: defaultHeartbeatInterval,
+		sessions:          session.NewManager(),
+		watch:             newWatchHub(),
+		auditLog:          auditLog,
+		recorder:          audit.NewRecorder(auditLog),
+		upstreamBaseURL:   os.Getenv("UPSTREAM_BASE_URL"),
+		adminToken:        os.Getenv("EMULATOR_ADMIN_TOKEN"),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
 func extractToken(r *http.Request) string {
@@ -41,6 +124,26 @@ func extractToken(r *http.Request) string {
 	return parts[1]
 }
 
+// streamDelayHeader is a request-level override for a streamed response's
+// inter-token pacing, for a test that wants to inject delay a loaded script
+// didn't ask for without reloading it. It takes precedence over both the
+// rule's own Timing.InterTokenMs and the writer's built-in default.
+const streamDelayHeader = "X-Emulator-Stream-Delay"
+
+// parseStreamDelayHeader reads streamDelayHeader off r, returning 0 (no
+// override) if it's absent or not a non-negative integer.
+func parseStreamDelayHeader(r *http.Request) int {
+	v := r.Header.Get(streamDelayHeader)
+	if v == "" {
+		return 0
+	}
+	ms, err := strconv.Atoi(v)
+	if err != nil || ms < 0 {
+		return 0
+	}
+	return ms
+}
+
 func writeError(w http.ResponseWriter, status int, message, errorType string, param, code *string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -57,14 +160,88 @@ func writeError(w http.ResponseWriter, status int, message, errorType string, pa
 	json.NewEncoder(w).Encode(errResp)
 }
 
+// estimatePromptTokens approximates prompt token usage the same way the
+// rest of the server approximates completion tokens (len/4), so
+// Script.RateLimits' TPM bucket has something to enforce against without
+// wiring in a real tokenizer.
+func estimatePromptTokens(requestData map[string]interface{}) int {
+	if messages, ok := requestData["messages"].([]interface{}); ok {
+		total := 0
+		for _, m := range messages {
+			if msg, ok := m.(map[string]interface{}); ok {
+				if content, ok := msg["content"].(string); ok {
+					total += len(content) / 4
+				}
+			}
+		}
+		return total
+	}
+	if prompt, ok := requestData["prompt"].(string); ok {
+		return len(prompt) / 4
+	}
+	return 0
+}
+
+// writeRateLimitExceeded writes an OpenAI-shaped 429 with Retry-After and
+// x-ratelimit-* headers, used both by the automatic Script.RateLimits
+// policy enforcement in HandleOpenAIRequest and by a scripted
+// Fault{Kind: script.FaultRateLimit} response.
+func writeRateLimitExceeded(w http.ResponseWriter, result script.RateLimitResult) {
+	axis := result.Exceeded
+	if axis == "" {
+		axis = "requests"
+	}
+
+	retrySeconds := int(result.ResetAfter.Seconds())
+	if retrySeconds < 1 {
+		retrySeconds = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(retrySeconds))
+	w.Header().Set("x-ratelimit-limit-"+axis, strconv.Itoa(result.Limit))
+	w.Header().Set("x-ratelimit-remaining-"+axis, strconv.Itoa(result.Remaining))
+	w.Header().Set("x-ratelimit-reset-"+axis, result.ResetAfter.String())
+
+	writeError(w, http.StatusTooManyRequests, fmt.Sprintf("Rate limit reached for %s", axis), "rate_limit_exceeded", nil, nil)
+}
+
+// HandleHealthz is the liveness probe: it always reports ok, since a
+// process that can answer HTTP at all is alive by definition.
 func (s *Server) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
+// HandleReadyz is the readiness probe: it reports ok until SetDraining(true)
+// is called, at which point it reports 503 so a load balancer stops
+// routing new requests here while main.go's graceful shutdown drains the
+// ones already in flight.
 func (s *Server) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if s.isDraining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"status": "draining"})
+		return
+	}
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// SetDraining flips whether HandleReadyz reports ready. main.go calls
+// SetDraining(true) as soon as it catches SIGTERM, ahead of the
+// httpServer.Shutdown grace window, so in-flight requests keep being
+// served while new traffic stops arriving.
+func (s *Server) SetDraining(draining bool) {
+	s.drainingMu.Lock()
+	defer s.drainingMu.Unlock()
+	s.draining = draining
+}
+
+func (s *Server) isDraining() bool {
+	s.drainingMu.RLock()
+	defer s.drainingMu.RUnlock()
+	return s.draining
 }
 
 func (s *Server) HandleScript(w http.ResponseWriter, r *http.Request) {
@@ -90,11 +267,45 @@ func (s *Server) HandleScript(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, err.Error(), "server_error", nil, nil)
 		return
 	}
-	
+
+	s.watch.emit(token, WatchEvent{Type: WatchScriptLoaded, Time: time.Now()})
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": "loaded"})
 }
 
+// HandleSnapshot returns every token's loaded script, cursor position, and
+// remaining responses as a JSON document (see script.Engine.Snapshot), so
+// a test suite can freeze emulator state between phases and reload it
+// later with POST /_emulator/restore.
+func (s *Server) HandleSnapshot(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := s.engine.Snapshot(w); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error(), "server_error", nil, nil)
+		return
+	}
+}
+
+// HandleRestore atomically replaces every token's loaded script with the
+// snapshot document in the request body (as produced by GET
+// /_emulator/snapshot). If adminToken is configured, the request's bearer
+// token must match it; otherwise restore is open to any caller, the same
+// way the rest of the emulator has no concept of a privileged client.
+func (s *Server) HandleRestore(w http.ResponseWriter, r *http.Request) {
+	if s.adminToken != "" && extractToken(r) != s.adminToken {
+		writeError(w, http.StatusUnauthorized, "Missing or invalid authorization", "auth_error", nil, nil)
+		return
+	}
+
+	if err := s.engine.Restore(r.Body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), "invalid_request_error", nil, nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "restored"})
+}
+
 func (s *Server) HandleReset(w http.ResponseWriter, r *http.Request) {
 	token := extractToken(r)
 	if token == "" {
@@ -102,7 +313,7 @@ func (s *Server) HandleReset(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	s.engine.Reset(token)
+	s.engine.ResetSession(token)
 	
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": "reset"})
@@ -130,63 +341,366 @@ func (s *Server) HandleState(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(debugInfo)
 }
 
+// HandleAudit returns every entry currently in the audit log as
+// newline-delimited JSON, oldest first.
+func (s *Server) HandleAudit(w http.ResponseWriter, r *http.Request) {
+	if !s.debug {
+		writeError(w, http.StatusForbidden, "Debug mode not enabled", "forbidden", nil, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	if err := s.auditLog.WriteNDJSON(w); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to write audit log", "server_error", nil, nil)
+	}
+}
+
+// HandleAuditHAR returns the audit log as a HAR 1.2 document, importable
+// into any HAR viewer.
+func (s *Server) HandleAuditHAR(w http.ResponseWriter, r *http.Request) {
+	if !s.debug {
+		writeError(w, http.StatusForbidden, "Debug mode not enabled", "forbidden", nil, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(audit.BuildHAR(s.auditLog.All()))
+}
+
+// HandleAuditToScript converts the audit log's recorded-proxy turns (see
+// audit.ToScript) into a Script and returns it as JSON, ready to be fed
+// back into POST /_emulator/script for replay without the real upstream.
+func (s *Server) HandleAuditToScript(w http.ResponseWriter, r *http.Request) {
+	if !s.debug {
+		writeError(w, http.StatusForbidden, "Debug mode not enabled", "forbidden", nil, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(audit.ToScript(s.auditLog.All()))
+}
+
+// HandleRecordStart begins recording /v1/* traffic against s.upstreamBaseURL
+// instead of serving it from the loaded script.
+func (s *Server) HandleRecordStart(w http.ResponseWriter, r *http.Request) {
+	if !s.debug {
+		writeError(w, http.StatusForbidden, "Debug mode not enabled", "forbidden", nil, nil)
+		return
+	}
+
+	if err := s.recorder.Start(s.upstreamBaseURL); err != nil {
+		writeError(w, http.StatusConflict, err.Error(), "invalid_request_error", nil, nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "recording"})
+}
+
+// HandleRecordStop ends recording started by HandleRecordStart.
+func (s *Server) HandleRecordStop(w http.ResponseWriter, r *http.Request) {
+	if !s.debug {
+		writeError(w, http.StatusForbidden, "Debug mode not enabled", "forbidden", nil, nil)
+		return
+	}
+
+	s.recorder.Stop()
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "stopped"})
+}
+
 func (s *Server) HandleOpenAIRequest(w http.ResponseWriter, r *http.Request) {
 	token := extractToken(r)
 	if token == "" {
 		writeError(w, http.StatusUnauthorized, "Missing or invalid authorization", "auth_error", nil, nil)
 		return
 	}
-	
+
+	// While recording is active, /v1/* goes straight to the real upstream
+	// instead of the scripted emulation path below, capturing the round
+	// trip for later replay via /_emulator/audit/to-script.
+	if s.recorder.Active() {
+		s.recorder.ProxyAndRecord(w, r, audit.HashToken(token))
+		return
+	}
+
+	// ctx bounds everything from here on: it's done either when the client
+	// disconnects or, if token's script set TimeoutMs, when that deadline
+	// elapses first. Streaming writers already select on it; the
+	// non-streaming paths check it once, right before delivery.
+	ctx, cancel := s.requestContext(r, token)
+	defer cancel()
+
+	start := time.Now()
+	capture := &auditResponseWriter{ResponseWriter: w, status: http.StatusOK}
+	w = capture
+
+	matchedIndex := -1
+	var requestBody []byte
+
+	defer func() {
+		s.auditLog.Record(audit.Entry{
+			Time:             start,
+			Method:           r.Method,
+			Path:             r.URL.Path,
+			TokenHash:        audit.HashToken(token),
+			Request:          requestBody,
+			MatchedRuleIndex: matchedIndex,
+			Status:           capture.status,
+			ResponseBytes:    capture.bytes,
+			StreamChunks:     capture.writeCalls,
+			Latency:          time.Since(start),
+		})
+	}()
+
 	// Handle models endpoints separately (they are GET requests without body)
 	if strings.Contains(r.URL.Path, "/models") {
 		s.writeModelResponse(w, r.URL.Path, token)
 		return
 	}
-	
+
+	// Audio transcriptions arrive as multipart/form-data, not JSON, so they
+	// get their own path rather than the io.ReadAll+json.Unmarshal below.
+	if strings.Contains(r.URL.Path, "/audio/transcriptions") {
+		matchedIndex, requestBody = s.handleTranscription(ctx, w, r, token)
+		return
+	}
+
 	body, err := readRequestBody(r)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, "Failed to read request body", "invalid_request_error", nil, nil)
 		return
 	}
-	
+	requestBody = body
+
 	s.logDebug(r, token, body)
-	
+
+	var requestData map[string]interface{}
+
 	// Validate JSON and model for non-GET requests
 	if r.Method != "GET" && len(body) > 0 {
-		var requestData map[string]interface{}
 		if err := json.Unmarshal(body, &requestData); err != nil {
 			writeError(w, http.StatusBadRequest, "Invalid JSON", "invalid_request_error", nil, nil)
 			return
 		}
-		
+
 		// Validate model if present
 		if model, hasModel := requestData["model"].(string); hasModel {
 			if !s.engine.ValidateModel(token, model) {
 				modelParam := "model"
+				s.watch.emit(token, WatchEvent{Type: WatchErrorReturned, Time: time.Now(), ErrorType: "invalid_request_error", Status: http.StatusNotFound})
 				writeError(w, http.StatusNotFound, fmt.Sprintf("The model `%s` does not exist", model), "invalid_request_error", &modelParam, nil)
 				return
 			}
+
+			// Enforce Script.RateLimits before any rule is matched, so a
+			// loaded RPM/TPM policy applies to every request against that
+			// model regardless of which rule would otherwise answer it.
+			if result := s.engine.CheckRateLimit(token, model, estimatePromptTokens(requestData)); !result.Allowed {
+				s.watch.emit(token, WatchEvent{Type: WatchErrorReturned, Time: time.Now(), ErrorType: "rate_limit_error", Status: http.StatusTooManyRequests})
+				writeRateLimitExceeded(w, result)
+				return
+			}
 		}
 	}
-	
-	// Extract user message from request
-	message := script.ExtractUserMessage(body)
-	
-	// Get response content from engine
-	responseContent, err := s.engine.MatchRequest(token, message)
+
+	// Embeddings are computed directly rather than through the rule
+	// engine, since their content is a deterministic function of the
+	// input text rather than something a script author would script turn
+	// by turn.
+	if strings.Contains(r.URL.Path, "/embeddings") {
+		s.writeEmbeddingResponse(w, token, requestData)
+		return
+	}
+
+	// Get the matching response rule from the engine. Passing the token's
+	// session lets a rule's Match.SessionMatch/Response.SessionUpdate read
+	// and mutate state that survives across requests (turn counters,
+	// which tool was last called, ...) independent of the engine's own
+	// per-token vars scratchpad.
+	response, idx, err := s.engine.MatchRequestWithIndex(token, r.Method, r.URL.Path, body, s.sessions.GetOrCreateSession(token))
+	matchedIndex = idx
 	if err != nil {
+		s.watch.emit(token, WatchEvent{Type: WatchErrorReturned, Time: time.Now(), ErrorType: "server_error", Status: http.StatusInternalServerError})
 		writeError(w, http.StatusInternalServerError, fmt.Sprintf("No matching rule: %v", err), "server_error", nil, nil)
 		return
 	}
-	
-	// Write the response in appropriate format for the endpoint
-	s.writeFormattedResponse(w, r.URL.Path, body, responseContent)
+
+	// A deadline that already elapsed (most likely a very small
+	// Script.TimeoutMs) means the rule matched above never gets delivered;
+	// refund it rather than reporting it consumed.
+	if ctx.Err() != nil {
+		s.engine.RefundRule(token, idx)
+		s.watch.emit(token, WatchEvent{Type: WatchErrorReturned, Time: time.Now(), ErrorType: "deadline_exceeded", Status: http.StatusGatewayTimeout})
+		writeError(w, http.StatusGatewayTimeout, "Request exceeded its configured deadline", "deadline_exceeded", nil, nil)
+		return
+	}
+
+	// Write the response in appropriate format for the endpoint. A
+	// cancelled delivery (the client disconnected, or the deadline elapsed
+	// mid-stream) refunds the rule instead of reporting it consumed, so a
+	// retried request sees the same script entry.
+	if s.writeFormattedResponse(ctx, w, token, r.URL.Path, body, response, parseStreamDelayHeader(r)) {
+		s.engine.RefundRule(token, idx)
+		return
+	}
+	s.emitRuleConsumed(token, idx)
+}
+
+// auditResponseWriter wraps the real http.ResponseWriter to tally the
+// status, byte count, and write-call count (a proxy for SSE chunk count)
+// HandleOpenAIRequest's audit entry needs, without buffering the body
+// itself.
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status     int
+	bytes      int
+	writeCalls int
+}
+
+func (a *auditResponseWriter) WriteHeader(status int) {
+	a.status = status
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func (a *auditResponseWriter) Write(b []byte) (int, error) {
+	n, err := a.ResponseWriter.Write(b)
+	a.bytes += n
+	a.writeCalls++
+	return n, err
+}
+
+func (a *auditResponseWriter) Flush() {
+	if f, ok := a.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
 }
 
 func readRequestBody(r *http.Request) ([]byte, error) {
 	return io.ReadAll(r.Body)
 }
 
+// handleTranscription parses a multipart/form-data POST to
+// /v1/audio/transcriptions and runs it through the rule engine like any
+// other scripted request, encoding the uploaded file's name and size into
+// a synthetic JSON body so Match.Pattern/Match.JSON can gate on them
+// without the engine needing to know multipart exists.
+// handleTranscription returns the matched rule index and the synthetic
+// JSON body it fed through the engine, so its caller in
+// HandleOpenAIRequest can fill in the audit entry for this request. Both
+// are zero-valued (-1, nil) if it returns before a body was built.
+func (s *Server) handleTranscription(ctx context.Context, w http.ResponseWriter, r *http.Request, token string) (int, []byte) {
+	if err := r.ParseMultipartForm(maxTranscriptionUpload); err != nil {
+		writeError(w, http.StatusBadRequest, "Failed to parse multipart form", "invalid_request_error", nil, nil)
+		return -1, nil
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		fileParam := "file"
+		writeError(w, http.StatusBadRequest, "Missing required parameter: 'file'", "invalid_request_error", &fileParam, nil)
+		return -1, nil
+	}
+	file.Close()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"model":     r.FormValue("model"),
+		"file_name": header.Filename,
+		"file_size": header.Size,
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to encode upload metadata", "server_error", nil, nil)
+		return -1, nil
+	}
+
+	s.logDebug(r, token, body)
+
+	response, idx, err := s.engine.MatchRequestWithIndex(token, r.Method, r.URL.Path, body, s.sessions.GetOrCreateSession(token))
+	if err != nil {
+		s.watch.emit(token, WatchEvent{Type: WatchErrorReturned, Time: time.Now(), ErrorType: "server_error", Status: http.StatusInternalServerError})
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("No matching rule: %v", err), "server_error", nil, nil)
+		return idx, body
+	}
+
+	if ctx.Err() != nil {
+		s.engine.RefundRule(token, idx)
+		s.watch.emit(token, WatchEvent{Type: WatchErrorReturned, Time: time.Now(), ErrorType: "deadline_exceeded", Status: http.StatusGatewayTimeout})
+		writeError(w, http.StatusGatewayTimeout, "Request exceeded its configured deadline", "deadline_exceeded", nil, nil)
+		return idx, body
+	}
+	s.emitRuleConsumed(token, idx)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.TranscriptionResponse{Text: response.Content})
+	return idx, body
+}
+
+// writeEmbeddingResponse returns a deterministic pseudo-random embedding
+// vector for each string in the request's "input" (a single string or an
+// array of strings). Each vector is seeded from a hash of its input, so
+// the same input always yields the same vector (stable assertions in
+// tests) while different inputs diverge. Dimensionality is per-model, via
+// Engine.EmbeddingDims.
+func (s *Server) writeEmbeddingResponse(w http.ResponseWriter, token string, requestData map[string]interface{}) {
+	model, _ := requestData["model"].(string)
+
+	var inputs []string
+	switch in := requestData["input"].(type) {
+	case string:
+		inputs = []string{in}
+	case []interface{}:
+		for _, v := range in {
+			if str, ok := v.(string); ok {
+				inputs = append(inputs, str)
+			}
+		}
+	}
+
+	dims := s.engine.EmbeddingDims(token, model)
+
+	data := make([]models.EmbeddingObject, len(inputs))
+	promptTokens := 0
+	for i, input := range inputs {
+		data[i] = models.EmbeddingObject{
+			Object:    "embedding",
+			Index:     i,
+			Embedding: deterministicEmbedding(input, dims),
+		}
+		promptTokens += len(input) / 4
+	}
+
+	completion := models.EmbeddingResponse{
+		Object: "list",
+		Data:   data,
+		Model:  model,
+		Usage: &models.Usage{
+			PromptTokens: promptTokens,
+			TotalTokens:  promptTokens,
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(completion)
+}
+
+// deterministicEmbedding generates a dims-length vector of values in
+// [-1, 1), seeded from a hash of input so the same input always produces
+// the same vector.
+func deterministicEmbedding(input string, dims int) []float64 {
+	sum := sha256.Sum256([]byte(input))
+	seed := int64(binary.BigEndian.Uint64(sum[:8]))
+	rng := mathrand.New(mathrand.NewSource(seed))
+
+	vec := make([]float64, dims)
+	for i := range vec {
+		vec[i] = rng.Float64()*2 - 1
+	}
+	return vec
+}
+
 func (s *Server) logDebug(r *http.Request, token string, body []byte) {
 	if !s.debug {
 		return
@@ -197,7 +711,14 @@ func (s *Server) logDebug(r *http.Request, token string, body []byte) {
 	}
 }
 
-func (s *Server) writeFormattedResponse(w http.ResponseWriter, path string, requestBody []byte, content string) {
+// writeFormattedResponse writes response in the appropriate format for
+// path, and reports whether delivery was cut short by ctx being done
+// (a client disconnect or an expired Script.TimeoutMs deadline) rather than
+// completing normally. Its caller uses that to decide whether the rule
+// that answered the request should have its use refunded via
+// Engine.RefundRule, since a partially-delivered response shouldn't
+// advance the script's cursor.
+func (s *Server) writeFormattedResponse(ctx context.Context, w http.ResponseWriter, token, path string, requestBody []byte, response *script.ResponseRule, streamDelayMs int) bool {
 	// Parse request to check if streaming is requested
 	var req map[string]interface{}
 	json.Unmarshal(requestBody, &req)
@@ -205,25 +726,172 @@ func (s *Server) writeFormattedResponse(w http.ResponseWriter, path string, requ
 	if stream, ok := req["stream"].(bool); ok {
 		isStreaming = stream
 	}
-	
+
+	if response.Fault != nil {
+		s.writeFault(ctx, w, response.Fault, response.Status, req)
+		return false
+	}
+
+	// A rule with an explicit SSE event list streams those literal frames
+	// (with their own per-event pacing and the rule's InitialDelayMs/
+	// FailAfter) instead of the generated per-word chunks below.
+	if isStreaming && len(response.SSE) > 0 {
+		return s.streamScriptedSSE(ctx, w, response.SSE, response.InitialDelayMs, response.FailAfter)
+	}
+
+	content := response.Content
+	toolCalls := convertToolCalls(response.ToolCalls)
+	timing := applyStreamDelayOverride(applyInitialDelay(response.Timing, response.InitialDelayMs), streamDelayMs)
+
 	// Format response based on endpoint
 	if strings.Contains(path, "/chat/completions") {
 		if isStreaming {
-			s.writeChatCompletionStream(w, content, req)
-		} else {
-			s.writeChatCompletion(w, content, req, http.StatusOK)
+			result := s.writeChatCompletionStream(ctx, w, token, content, toolCalls, req, timing)
+			return result.Cancelled
 		}
+		s.writeChatCompletion(w, content, toolCalls, req, http.StatusOK)
 	} else if strings.Contains(path, "/completions") || strings.Contains(path, "/responses") {
 		if isStreaming {
-			s.writeCompletionStream(w, content, req)
-		} else {
-			s.writeCompletion(w, content, req, http.StatusOK)
+			result := s.writeCompletionStream(ctx, w, token, content, req, timing)
+			return result.Cancelled
 		}
+		s.writeCompletion(w, content, req, http.StatusOK)
 	} else {
 		// Default: return as plain text
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(content))
 	}
+	return false
+}
+
+// writeFault simulates a transport or provider-level failure instead of a
+// normal completion, per fault.Kind. These are common enough failure modes
+// for client resilience testing (429 backoff, mid-stream disconnects,
+// malformed frames, a server that never answers) that they get handled
+// directly rather than requiring a script author to hand-roll them with
+// Status and a raw SSE body.
+func (s *Server) writeFault(ctx context.Context, w http.ResponseWriter, fault *script.Fault, status int, req map[string]interface{}) {
+	switch fault.Kind {
+	case script.FaultRateLimit:
+		retryAfter := time.Duration(fault.RetryAfterMs) * time.Millisecond
+		if retryAfter <= 0 {
+			retryAfter = time.Second
+		}
+		writeRateLimitExceeded(w, script.RateLimitResult{ResetAfter: retryAfter, Exceeded: "requests"})
+
+	case script.FaultServerError:
+		st := status
+		if st == 0 {
+			st = http.StatusServiceUnavailable
+		}
+		writeError(w, st, "The server had an error processing your request", "server_error", nil, nil)
+
+	case script.FaultTimeout:
+		// Never write a response; the client is left to enforce its own
+		// read/request timeout. We still watch ctx so the handler goroutine
+		// exits once the client gives up rather than leaking forever.
+		<-ctx.Done()
+
+	case script.FaultTruncateStream:
+		writeTruncatedStream(w, fault.AfterBytes)
+
+	case script.FaultInvalidSSE:
+		writeInvalidSSE(w)
+
+	case script.FaultSlowBody:
+		model := "gpt-4"
+		if m, ok := req["model"].(string); ok {
+			model = m
+		}
+		writeSlowBody(w, model, fault.RetryAfterMs)
+
+	default:
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("unknown fault kind %q", fault.Kind), "server_error", nil, nil)
+	}
+}
+
+// writeTruncatedStream opens an SSE stream, writes plausible chunk frames
+// until afterBytes have gone out, then stops without a terminating [DONE]
+// event — modeling a connection that drops mid-stream instead of
+// completing cleanly.
+func writeTruncatedStream(w http.ResponseWriter, afterBytes int) {
+	setSSEHeaders(w)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return
+	}
+	if afterBytes <= 0 {
+		afterBytes = 64
+	}
+
+	id := generateID("chatcmpl")
+	written := 0
+	for i := 0; written < afterBytes; i++ {
+		chunk := models.ChatCompletion{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: time.Now().Unix(),
+			Choices: []models.ChatChoice{
+				{
+					Index: 0,
+					Delta: &models.ChatMessage{Content: fmt.Sprintf("word%d ", i)},
+				},
+			},
+		}
+		data, _ := json.Marshal(chunk)
+		n, _ := fmt.Fprintf(w, "data: %s\n\n", data)
+		written += n
+		flusher.Flush()
+	}
+}
+
+// writeInvalidSSE emits a single malformed "data:" frame (truncated JSON,
+// no closing brace) and stops, for testing a client's SSE parser against a
+// misbehaving server rather than a clean [DONE]-terminated stream.
+func writeInvalidSSE(w http.ResponseWriter) {
+	setSSEHeaders(w)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return
+	}
+	fmt.Fprint(w, "data: {\"id\": \"chatcmpl-fault\", \"choices\": [{\"delta\": {\"content\": \"oh no\n\n")
+	flusher.Flush()
+}
+
+// writeSlowBody writes a normal chat completion JSON body one byte at a
+// time, delayMs apart, for testing a client's read-timeout handling
+// against a server that does respond but trickles the body out.
+func writeSlowBody(w http.ResponseWriter, model string, delayMs int) {
+	completion := models.ChatCompletion{
+		ID:      generateID("chatcmpl"),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   model,
+		Choices: []models.ChatChoice{
+			{
+				Index:        0,
+				Message:      &models.ChatMessage{Role: "assistant", Content: "slow response"},
+				FinishReason: stringPtr("stop"),
+			},
+		},
+	}
+	data, _ := json.Marshal(completion)
+
+	delay := time.Duration(delayMs) * time.Millisecond
+	if delay <= 0 {
+		delay = 20 * time.Millisecond
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	for i := range data {
+		w.Write(data[i : i+1])
+		if flusher != nil {
+			flusher.Flush()
+		}
+		time.Sleep(delay)
+	}
 }
 
 func setSSEHeaders(w http.ResponseWriter) {
@@ -296,12 +964,22 @@ func (s *Server) writeModelResponse(w http.ResponseWriter, path string, token st
 	}
 }
 
-func (s *Server) writeChatCompletion(w http.ResponseWriter, content string, req map[string]interface{}, status int) {
+func (s *Server) writeChatCompletion(w http.ResponseWriter, content string, toolCalls []models.ToolCall, req map[string]interface{}, status int) {
 	model := "gpt-4"
 	if m, ok := req["model"].(string); ok {
 		model = m
 	}
-	
+
+	finishReason := "stop"
+	message := &models.ChatMessage{
+		Role:    "assistant",
+		Content: content,
+	}
+	if len(toolCalls) > 0 {
+		message.ToolCalls = toolCalls
+		finishReason = "tool_calls"
+	}
+
 	completion := models.ChatCompletion{
 		ID:      generateID("chatcmpl"),
 		Object:  "chat.completion",
@@ -309,12 +987,9 @@ func (s *Server) writeChatCompletion(w http.ResponseWriter, content string, req
 		Model:   model,
 		Choices: []models.ChatChoice{
 			{
-				Index: 0,
-				Message: &models.ChatMessage{
-					Role:    "assistant",
-					Content: content,
-				},
-				FinishReason: stringPtr("stop"),
+				Index:        0,
+				Message:      message,
+				FinishReason: stringPtr(finishReason),
 			},
 		},
 		Usage: &models.Usage{
@@ -323,81 +998,168 @@ func (s *Server) writeChatCompletion(w http.ResponseWriter, content string, req
 			TotalTokens:      10 + len(content)/4,
 		},
 	}
-	
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(completion)
 }
 
+// convertToolCalls turns a script's tool call specs into the OpenAI wire
+// format, assigning a call ID where the script didn't specify one.
+func convertToolCalls(specs []script.ToolCallSpec) []models.ToolCall {
+	if len(specs) == 0 {
+		return nil
+	}
+	calls := make([]models.ToolCall, len(specs))
+	for i, spec := range specs {
+		id := spec.ID
+		if id == "" {
+			id = generateID("call")
+		}
+		calls[i] = models.ToolCall{
+			ID:   id,
+			Type: "function",
+			Function: models.ToolCallFunction{
+				Name:      spec.Name,
+				Arguments: spec.Arguments,
+			},
+		}
+	}
+	return calls
+}
+
 func stringPtr(s string) *string {
 	return &s
 }
 
-func (s *Server) writeChatCompletionStream(w http.ResponseWriter, content string, req map[string]interface{}) {
+// StreamResult reports what actually happened during a streamed completion,
+// so the handler can log accurate usage even when the client disconnected
+// partway through.
+type StreamResult struct {
+	Delivered   int           // number of content chunks actually written
+	Elapsed     time.Duration // time spent from first write to last
+	Cancelled   bool          // true if ctx was done before the stream finished naturally
+	CancelCause error         // ctx.Err() when Cancelled is true
+}
+
+func (s *Server) writeChatCompletionStream(ctx context.Context, w http.ResponseWriter, token, content string, toolCalls []models.ToolCall, req map[string]interface{}, timing *script.Timing) StreamResult {
 	model := "gpt-4"
 	if m, ok := req["model"].(string); ok {
 		model = m
 	}
-	
-	setSSEHeaders(w)
+
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
-		return
+		return StreamResult{}
 	}
-	
+	setSSEHeaders(w)
+
+	start := time.Now()
 	id := generateID("chatcmpl")
-	
-	// Send initial chunk with role
-	chunk := models.ChatCompletion{
-		ID:      id,
-		Object:  "chat.completion.chunk",
-		Created: time.Now().Unix(),
-		Model:   model,
-		Choices: []models.ChatChoice{
-			{
-				Index: 0,
-				Delta: &models.ChatMessage{
-					Role: "assistant",
-				},
-			},
-		},
+
+	result := StreamResult{}
+	pacing := resolvePacing(timing)
+
+	var writeCancelCh <-chan struct{}
+	if session := s.engine.Session(token); session != nil {
+		writeCancelCh = session.WriteCancelCh()
 	}
-	
-	data, _ := json.Marshal(chunk)
-	fmt.Fprintf(w, "data: %s\n\n", data)
-	flusher.Flush()
-	time.Sleep(10 * time.Millisecond)
-	
-	// Send content in chunks
-	words := strings.Fields(content)
-	for i, word := range words {
-		chunk := models.ChatCompletion{
-			ID:      id,
-			Object:  "chat.completion.chunk",
-			Created: time.Now().Unix(),
-			Model:   model,
-			Choices: []models.ChatChoice{
-				{
-					Index: 0,
-					Delta: &models.ChatMessage{
-						Content: word,
-					},
-				},
-			},
+
+	for chunk := range generateChunks(ctx, id, model, content) {
+		delay := pacing.delay()
+		if result.Delivered == 0 {
+			delay = pacing.firstToken
 		}
-		
-		if i < len(words)-1 {
-			chunk.Choices[0].Delta.Content += " "
+		if !sleepOrCancel(ctx, writeCancelCh, delay) {
+			result.Cancelled = true
+			if ctx.Err() != nil {
+				result.CancelCause = ctx.Err()
+			} else {
+				result.CancelCause = ErrSessionDeadline
+			}
+			break
 		}
-		
+
 		data, _ := json.Marshal(chunk)
 		fmt.Fprintf(w, "data: %s\n\n", data)
 		flusher.Flush()
-		time.Sleep(10 * time.Millisecond)
+
+		if chunk.Choices[0].Delta.Content != "" {
+			result.Delivered++
+		}
+
+		if pacing.stallAfter > 0 && result.Delivered >= pacing.stallAfter {
+			waitForever(ctx, writeCancelCh)
+			result.Cancelled = true
+			if ctx.Err() != nil {
+				result.CancelCause = ctx.Err()
+			} else {
+				result.CancelCause = ErrSessionDeadline
+			}
+			break
+		}
 	}
-	
-	// Send finish chunk
+
+	// The channel can also close early because generateChunks itself gave up
+	// on ctx before we observed it above (e.g. ctx was already done when the
+	// first chunk tried to send), so re-check here.
+	if !result.Cancelled && ctx.Err() != nil {
+		result.Cancelled = true
+		result.CancelCause = ctx.Err()
+	}
+
+	if !result.Cancelled {
+		for i, call := range toolCalls {
+			index := i
+			for fragIndex, fragment := range splitIntoFragments(call.Function.Arguments, 8) {
+				if !sleepOrCancel(ctx, writeCancelCh, pacing.delay()) {
+					result.Cancelled = true
+					if ctx.Err() != nil {
+						result.CancelCause = ctx.Err()
+					} else {
+						result.CancelCause = ErrSessionDeadline
+					}
+					break
+				}
+
+				delta := models.ToolCall{Index: &index, Function: models.ToolCallFunction{Arguments: fragment}}
+				if fragIndex == 0 {
+					delta.ID = call.ID
+					delta.Type = call.Type
+					delta.Function.Name = call.Function.Name
+				}
+
+				toolChunk := models.ChatCompletion{
+					ID:      id,
+					Object:  "chat.completion.chunk",
+					Created: time.Now().Unix(),
+					Model:   model,
+					Choices: []models.ChatChoice{
+						{
+							Index: 0,
+							Delta: &models.ChatMessage{ToolCalls: []models.ToolCall{delta}},
+						},
+					},
+				}
+				data, _ := json.Marshal(toolChunk)
+				fmt.Fprintf(w, "data: %s\n\n", data)
+				flusher.Flush()
+			}
+			if result.Cancelled {
+				break
+			}
+		}
+	}
+
+	finishReason := "stop"
+	if len(toolCalls) > 0 {
+		finishReason = "tool_calls"
+	}
+	if result.Cancelled {
+		finishReason = "cancelled"
+	}
+
 	finishChunk := models.ChatCompletion{
 		ID:      id,
 		Object:  "chat.completion.chunk",
@@ -407,18 +1169,23 @@ func (s *Server) writeChatCompletionStream(w http.ResponseWriter, content string
 			{
 				Index:        0,
 				Delta:        &models.ChatMessage{},
-				FinishReason: stringPtr("stop"),
+				FinishReason: stringPtr(finishReason),
 			},
 		},
 	}
-	
-	data, _ = json.Marshal(finishChunk)
-	fmt.Fprintf(w, "data: %s\n\n", data)
-	flusher.Flush()
-	
-	// Send [DONE]
-	fmt.Fprintf(w, "data: [DONE]\n\n")
+
+	finishData, _ := json.Marshal(finishChunk)
+	fmt.Fprintf(w, "data: %s\n\n", finishData)
 	flusher.Flush()
+
+	// A cancelled client is gone; don't bother writing [DONE] to it.
+	if !result.Cancelled {
+		fmt.Fprintf(w, "data: [DONE]\n\n")
+		flusher.Flush()
+	}
+
+	result.Elapsed = time.Since(start)
+	return result
 }
 
 func (s *Server) writeCompletion(w http.ResponseWriter, content string, req map[string]interface{}, status int) {
@@ -451,24 +1218,46 @@ func (s *Server) writeCompletion(w http.ResponseWriter, content string, req map[
 	json.NewEncoder(w).Encode(completion)
 }
 
-func (s *Server) writeCompletionStream(w http.ResponseWriter, content string, req map[string]interface{}) {
+func (s *Server) writeCompletionStream(ctx context.Context, w http.ResponseWriter, token, content string, req map[string]interface{}, timing *script.Timing) StreamResult {
 	model := "gpt-3.5-turbo-instruct"
 	if m, ok := req["model"].(string); ok {
 		model = m
 	}
-	
-	setSSEHeaders(w)
+
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
-		return
+		return StreamResult{}
 	}
-	
+	setSSEHeaders(w)
+
+	start := time.Now()
 	id := generateID("cmpl")
-	
-	// Send content in chunks
+
+	result := StreamResult{}
+	pacing := resolvePacing(timing)
 	words := strings.Fields(content)
+
+	var writeCancelCh <-chan struct{}
+	if session := s.engine.Session(token); session != nil {
+		writeCancelCh = session.WriteCancelCh()
+	}
+
 	for i, word := range words {
+		delay := pacing.delay()
+		if i == 0 {
+			delay = pacing.firstToken
+		}
+		if !sleepOrCancel(ctx, writeCancelCh, delay) {
+			result.Cancelled = true
+			if ctx.Err() != nil {
+				result.CancelCause = ctx.Err()
+			} else {
+				result.CancelCause = ErrSessionDeadline
+			}
+			break
+		}
+
 		chunk := models.TextCompletion{
 			ID:      id,
 			Object:  "text_completion",
@@ -481,35 +1270,84 @@ func (s *Server) writeCompletionStream(w http.ResponseWriter, content string, re
 				},
 			},
 		}
-		
+
 		if i < len(words)-1 {
 			chunk.Choices[0].Text += " "
 		}
-		
+
 		data, _ := json.Marshal(chunk)
 		fmt.Fprintf(w, "data: %s\n\n", data)
 		flusher.Flush()
-		time.Sleep(10 * time.Millisecond)
+		result.Delivered++
+
+		if pacing.stallAfter > 0 && result.Delivered >= pacing.stallAfter {
+			waitForever(ctx, writeCancelCh)
+			result.Cancelled = true
+			if ctx.Err() != nil {
+				result.CancelCause = ctx.Err()
+			} else {
+				result.CancelCause = ErrSessionDeadline
+			}
+			break
+		}
 	}
-	
-	// Send [DONE]
-	fmt.Fprintf(w, "data: [DONE]\n\n")
-	flusher.Flush()
+
+	if result.Cancelled {
+		finishChunk := models.TextCompletion{
+			ID:      id,
+			Object:  "text_completion",
+			Created: time.Now().Unix(),
+			Model:   model,
+			Choices: []models.ResponseChoice{
+				{
+					Index:        0,
+					FinishReason: "cancelled",
+				},
+			},
+		}
+		data, _ := json.Marshal(finishChunk)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	} else {
+		fmt.Fprintf(w, "data: [DONE]\n\n")
+		flusher.Flush()
+	}
+
+	result.Elapsed = time.Since(start)
+	return result
 }
 
+// registerWebSocketRoute is set by ws.go when the module is built with the
+// "websocket" build tag, wiring up /v1/chat/completions/ws. Build with
+// `-tags websocket` to include it; it is left nil otherwise so the default
+// build carries no dependency on gorilla/websocket.
+var registerWebSocketRoute func(*Server, *http.ServeMux)
+
 // setupRoutes creates the router with all handlers
 func (s *Server) setupRoutes() *http.ServeMux {
 	mux := http.NewServeMux()
-	
+
 	mux.HandleFunc("/healthz", s.HandleHealthz)
 	mux.HandleFunc("/readyz", s.HandleReadyz)
-	
+
 	mux.HandleFunc("POST /_emulator/script", s.HandleScript)
+	mux.HandleFunc("GET /_emulator/snapshot", s.HandleSnapshot)
+	mux.HandleFunc("POST /_emulator/restore", s.HandleRestore)
 	mux.HandleFunc("POST /_emulator/reset", s.HandleReset)
 	mux.HandleFunc("GET /_emulator/state", s.HandleState)
-	
+	mux.HandleFunc("GET /_emulator/watch", s.HandleWatch)
+	mux.HandleFunc("GET /_emulator/audit", s.HandleAudit)
+	mux.HandleFunc("GET /_emulator/audit/har", s.HandleAuditHAR)
+	mux.HandleFunc("POST /_emulator/audit/to-script", s.HandleAuditToScript)
+	mux.HandleFunc("POST /_emulator/record/start", s.HandleRecordStart)
+	mux.HandleFunc("POST /_emulator/record/stop", s.HandleRecordStop)
+
 	mux.HandleFunc("/v1/", s.HandleOpenAIRequest)
-	
+
+	if registerWebSocketRoute != nil {
+		registerWebSocketRoute(s, mux)
+	}
+
 	return mux
 }
 
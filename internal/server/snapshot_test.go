@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fabianvf/llemulator/internal/script"
+)
+
+func loadScript(t *testing.T, ts *httptest.Server, token string, responses []string) {
+	t.Helper()
+	body, _ := json.Marshal(map[string]interface{}{"reset": true, "responses": responses})
+	req, _ := http.NewRequest("POST", ts.URL+"/_emulator/script", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed to load script for %s: %v", token, err)
+	}
+	resp.Body.Close()
+}
+
+func chatContent(t *testing.T, ts *httptest.Server, token string) string {
+	t.Helper()
+	body, _ := json.Marshal(map[string]interface{}{
+		"model":    "gpt-4",
+		"messages": []map[string]interface{}{{"role": "user", "content": "go"}},
+	})
+	req, _ := http.NewRequest("POST", ts.URL+"/v1/chat/completions", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("chat request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("decoding chat response: %v", err)
+	}
+	choices, _ := result["choices"].([]interface{})
+	if len(choices) == 0 {
+		t.Fatalf("no choices in response: %v", result)
+	}
+	message := choices[0].(map[string]interface{})["message"].(map[string]interface{})
+	return message["content"].(string)
+}
+
+// TestSnapshotRestoreEndpointsRoundTrip verifies a script partially
+// consumed before GET /_emulator/snapshot resumes at the same point after
+// its document is fed to a fresh server via POST /_emulator/restore.
+func TestSnapshotRestoreEndpointsRoundTrip(t *testing.T) {
+	srv := NewServer()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	loadScript(t, ts, "snap-token", []string{"Dog", "Cat"})
+	if got := chatContent(t, ts, "snap-token"); got != "Dog" {
+		t.Fatalf("got %q, want Dog", got)
+	}
+
+	snapResp, err := http.Get(ts.URL + "/_emulator/snapshot")
+	if err != nil || snapResp.StatusCode != http.StatusOK {
+		t.Fatalf("snapshot request failed: %v", err)
+	}
+	snapshot, _ := io.ReadAll(snapResp.Body)
+	snapResp.Body.Close()
+
+	restored := NewServer()
+	restoredTS := httptest.NewServer(restored)
+	defer restoredTS.Close()
+
+	restoreReq, _ := http.NewRequest("POST", restoredTS.URL+"/_emulator/restore", bytes.NewReader(snapshot))
+	restoreResp, err := http.DefaultClient.Do(restoreReq)
+	if err != nil || restoreResp.StatusCode != http.StatusOK {
+		t.Fatalf("restore request failed: %v", err)
+	}
+	restoreResp.Body.Close()
+
+	if got := chatContent(t, restoredTS, "snap-token"); got != "Cat" {
+		t.Errorf("got %q, want Cat (Dog was already consumed before the snapshot)", got)
+	}
+}
+
+// TestRestoreRejectsWrongAdminToken verifies restore is gated by
+// adminToken when one is configured, without the caller needing to match
+// the token any loaded script uses.
+func TestRestoreRejectsWrongAdminToken(t *testing.T) {
+	srv := NewServer()
+	srv.adminToken = "super-secret"
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	engine := script.NewEngine()
+	engine.LoadScript("whatever", script.Script{Reset: true, Responses: []interface{}{"hi"}})
+	var buf bytes.Buffer
+	engine.Snapshot(&buf)
+
+	req, _ := http.NewRequest("POST", ts.URL+"/_emulator/restore", bytes.NewReader(buf.Bytes()))
+	req.Header.Set("Authorization", "Bearer wrong-token")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("restore request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+// TestHealthzAndReadyzReportStatus verifies the unauthenticated probes
+// return the documented JSON body, and that readyz flips to 503 once
+// SetDraining(true) is called.
+func TestHealthzAndReadyzReportStatus(t *testing.T) {
+	srv := NewServer()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `"ok"`) {
+			t.Errorf("%s: got status %d body %s, want 200 with status ok", path, resp.StatusCode, body)
+		}
+	}
+
+	srv.SetDraining(true)
+
+	resp, err := http.Get(ts.URL + "/readyz")
+	if err != nil {
+		t.Fatalf("GET /readyz: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want %d after SetDraining(true)", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fabianvf/llemulator/internal/script"
+)
+
+// defaultHeartbeatInterval is how often a keep-alive comment is emitted on an
+// otherwise idle SSE stream when the server wasn't configured with one.
+const defaultHeartbeatInterval = 15 * time.Second
+
+// streamSSEResponse writes a fixed sequence of script-defined SSE events to w.
+// It is a thin wrapper around streamSSEResponseContext for callers that don't
+// have a request context available (e.g. tests).
+func (s *Server) streamSSEResponse(w http.ResponseWriter, events []script.SSEEvent) {
+	s.streamSSEResponseContext(context.Background(), w, events)
+}
+
+// streamSSEResponseContext writes events as SSE data frames, interleaving
+// ": keep-alive" comment frames whenever the writer has been idle for longer
+// than the server's heartbeat interval. It stops as soon as ctx is done. It
+// is streamScriptedSSE with no InitialDelayMs/FailAfter, for callers that
+// just want a plain event list streamed.
+func (s *Server) streamSSEResponseContext(ctx context.Context, w http.ResponseWriter, events []script.SSEEvent) {
+	s.streamScriptedSSE(ctx, w, events, 0, nil)
+}
+
+// streamScriptedSSE is streamSSEResponseContext plus the two knobs a rule's
+// ResponseRule.SSE can carry alongside the event list: initialDelayMs
+// (ResponseRule.InitialDelayMs) delays the first event, and failAfter
+// (ResponseRule.FailAfter) truncates the stream with a scripted error once
+// its Events/AfterMs threshold is crossed instead of finishing normally. It
+// reports whether ctx was done before every event was sent, so its caller
+// knows not to treat the stream as having completed normally; a scripted
+// failAfter truncation is not reported as cancelled, since that's a
+// deliberate, fully-delivered fault rather than a client disconnect.
+func (s *Server) streamScriptedSSE(ctx context.Context, w http.ResponseWriter, events []script.SSEEvent, initialDelayMs int, failAfter *script.FailureSpec) bool {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return false
+	}
+	setSSEHeaders(w)
+
+	interval := s.heartbeatInterval
+	if interval <= 0 {
+		interval = defaultHeartbeatInterval
+	}
+
+	var writeMu sync.Mutex
+	lastWrite := time.Now()
+
+	write := func(data string) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		fmt.Fprint(w, data)
+		flusher.Flush()
+		lastWrite = time.Now()
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				writeMu.Lock()
+				idle := time.Since(lastWrite)
+				writeMu.Unlock()
+				if idle >= interval {
+					write(": keep-alive\n\n")
+				}
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	if initialDelayMs > 0 {
+		time.Sleep(time.Duration(initialDelayMs) * time.Millisecond)
+	}
+
+	for i, event := range events {
+		select {
+		case <-ctx.Done():
+			return true
+		default:
+		}
+
+		if failAfter != nil && failAfter.AfterMs > 0 && time.Since(start) >= time.Duration(failAfter.AfterMs)*time.Millisecond {
+			writeScriptedFailure(write, failAfter)
+			return false
+		}
+
+		write(formatSSEData(event.Data))
+
+		if failAfter != nil && failAfter.Events > 0 && i+1 >= failAfter.Events {
+			writeScriptedFailure(write, failAfter)
+			return false
+		}
+
+		time.Sleep(eventDelay(event))
+	}
+	return false
+}
+
+// eventDelay resolves a single SSEEvent's pacing: its own DelayMs/JitterMs
+// if set, falling back to the writer's long-standing 10ms default so
+// existing scripts (and tests) that never set these fields see unchanged
+// timing.
+func eventDelay(event script.SSEEvent) time.Duration {
+	delay := 10 * time.Millisecond
+	if event.DelayMs > 0 {
+		delay = time.Duration(event.DelayMs) * time.Millisecond
+	}
+	if event.JitterMs > 0 {
+		delay += time.Duration(rand.Int63n(int64(event.JitterMs))) * time.Millisecond
+	}
+	return delay
+}
+
+// writeScriptedFailure ends a scripted SSE stream per failAfter.Kind once
+// its threshold fires: FaultInvalidSSE writes a single malformed frame,
+// FaultServerError writes an error data frame at failAfter.Status, and
+// anything else (including the default, FaultTruncateStream) just stops
+// writing, dropping the connection without a terminating [DONE] event.
+func writeScriptedFailure(write func(string), failAfter *script.FailureSpec) {
+	switch failAfter.Kind {
+	case script.FaultInvalidSSE:
+		write(`data: {"incomplete`)
+	case script.FaultServerError:
+		status := failAfter.Status
+		if status == 0 {
+			status = http.StatusInternalServerError
+		}
+		data, _ := json.Marshal(map[string]interface{}{
+			"error": map[string]interface{}{
+				"message": "stream terminated",
+				"type":    "server_error",
+				"code":    status,
+			},
+		})
+		write(fmt.Sprintf("data: %s\n\n", data))
+	}
+}
+
+// formatSSEData renders a script.SSEEvent's data as an SSE "data:" frame,
+// special-casing the literal "[DONE]" sentinel so it's emitted unquoted as
+// OpenAI clients expect.
+func formatSSEData(raw json.RawMessage) string {
+	var decoded interface{}
+	if err := json.Unmarshal(raw, &decoded); err == nil {
+		if str, ok := decoded.(string); ok && str == "[DONE]" {
+			return "data: [DONE]\n\n"
+		}
+	}
+	return fmt.Sprintf("data: %s\n\n", raw)
+}
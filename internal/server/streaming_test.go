@@ -2,8 +2,9 @@ package server
 
 import (
 	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -78,7 +79,7 @@ func TestStreamChunking(t *testing.T) {
 	}
 	
 	recorder := httptest.NewRecorder()
-	server.writeChatCompletionStream(recorder, content, req)
+	server.writeChatCompletionStream(context.Background(), recorder, "", content, nil, req, nil)
 	
 	// Parse streamed chunks
 	body := recorder.Body.String()
@@ -100,8 +101,11 @@ func TestStreamChunking(t *testing.T) {
 		}
 	}
 	
-	// Reconstruct message from chunks
-	reconstructed := strings.Join(chunks, " ")
+	// Reconstruct message from chunks. No separator here: each non-final
+	// chunk already carries its own trailing space (see generateChunks),
+	// the same way a real client would just concatenate deltas with no
+	// separator of its own.
+	reconstructed := strings.Join(chunks, "")
 	
 	// Should preserve the original message with proper spacing
 	if !strings.Contains(reconstructed, "test message") {
@@ -125,46 +129,62 @@ func TestStreamCompletion(t *testing.T) {
 	}
 	
 	recorder := httptest.NewRecorder()
-	server.writeChatCompletionStream(recorder, content, req)
+	server.writeChatCompletionStream(context.Background(), recorder, "", content, nil, req, nil)
 	
 	body := recorder.Body.String()
 	lines := strings.Split(body, "\n")
-	
-	// Track the sequence of events
+
+	// Track the sequence of events as they arrive, since content is split
+	// one word per chunk (see generateChunks) and so never appears as a
+	// single contiguous string in the raw SSE dump to search for with
+	// strings.Index.
 	var hasRole bool
 	var hasContent bool
 	var hasFinishReason bool
 	var hasDone bool
-	
+
 	for _, line := range lines {
-		if strings.HasPrefix(line, "data: ") {
-			data := strings.TrimPrefix(line, "data: ")
-			
-			if data == "[DONE]" {
-				hasDone = true
-				continue
-			}
-			
-			var chunk models.ChatCompletion
-			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
-				continue
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		data := strings.TrimPrefix(line, "data: ")
+
+		if data == "[DONE]" {
+			if !hasFinishReason {
+				t.Fatal("[DONE] arrived before finish_reason")
 			}
-			
-			if chunk.Choices[0].Delta != nil {
-				if chunk.Choices[0].Delta.Role == "assistant" {
-					hasRole = true
-				}
-				if chunk.Choices[0].Delta.Content != "" {
-					hasContent = true
-				}
+			hasDone = true
+			continue
+		}
+
+		var chunk models.ChatCompletion
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			continue
+		}
+
+		if chunk.Choices[0].FinishReason != nil && *chunk.Choices[0].FinishReason == "stop" {
+			if !hasContent {
+				t.Fatal("finish_reason arrived before any content chunk")
 			}
-			
-			if chunk.Choices[0].FinishReason != nil && *chunk.Choices[0].FinishReason == "stop" {
-				hasFinishReason = true
+			hasFinishReason = true
+			continue
+		}
+
+		if chunk.Choices[0].Delta == nil {
+			continue
+		}
+		if chunk.Choices[0].Delta.Role == "assistant" {
+			hasRole = true
+			continue
+		}
+		if chunk.Choices[0].Delta.Content != "" {
+			if !hasRole {
+				t.Fatal("content chunk arrived before role chunk")
 			}
+			hasContent = true
 		}
 	}
-	
+
 	// Verify complete sequence
 	if !hasRole {
 		t.Error("Stream missing initial role chunk")
@@ -178,16 +198,6 @@ func TestStreamCompletion(t *testing.T) {
 	if !hasDone {
 		t.Error("Stream missing [DONE] termination")
 	}
-	
-	// Verify order: role -> content -> finish -> done
-	roleIndex := strings.Index(body, `"role":"assistant"`)
-	contentIndex := strings.Index(body, content)
-	finishIndex := strings.Index(body, `"finish_reason":"stop"`)
-	doneIndex := strings.Index(body, "[DONE]")
-	
-	if roleIndex > contentIndex || contentIndex > finishIndex || finishIndex > doneIndex {
-		t.Error("Stream events in wrong order")
-	}
 }
 
 // TestFlushBehavior verifies events flush immediately
@@ -320,14 +330,29 @@ func TestConcurrentStreaming(t *testing.T) {
 				"stream": true,
 			}
 			
-			server.writeChatCompletionStream(recorder, content, req)
-			
-			// Verify response contains expected content
-			body := recorder.Body.String()
-			if !strings.Contains(body, content) {
-				t.Errorf("Stream %d missing content", id)
+			server.writeChatCompletionStream(context.Background(), recorder, "", content, nil, req, nil)
+
+			// Reconstruct the delivered content by concatenating every
+			// delta directly, the same way a real client would: content is
+			// split one word per chunk (see generateChunks), so it never
+			// appears as a single contiguous string in the raw SSE dump.
+			var reconstructed strings.Builder
+			for _, line := range strings.Split(recorder.Body.String(), "\n") {
+				if !strings.HasPrefix(line, "data: ") || strings.Contains(line, "[DONE]") {
+					continue
+				}
+				var chunk models.ChatCompletion
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &chunk); err != nil {
+					continue
+				}
+				if chunk.Choices[0].Delta != nil {
+					reconstructed.WriteString(chunk.Choices[0].Delta.Content)
+				}
+			}
+			if reconstructed.String() != content {
+				t.Errorf("Stream %d missing content: got %q, want %q", id, reconstructed.String(), content)
 			}
-			if !strings.Contains(body, "[DONE]") {
+			if !strings.Contains(recorder.Body.String(), "[DONE]") {
 				t.Errorf("Stream %d missing termination", id)
 			}
 			
@@ -371,7 +396,7 @@ func TestStreamingWordBoundaries(t *testing.T) {
 	}
 	
 	recorder := httptest.NewRecorder()
-	server.writeChatCompletionStream(recorder, content, req)
+	server.writeChatCompletionStream(context.Background(), recorder, "", content, nil, req, nil)
 	
 	body := recorder.Body.String()
 	scanner := bufio.NewScanner(strings.NewReader(body))
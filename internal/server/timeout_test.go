@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fabianvf/llemulator/internal/models"
+	"github.com/fabianvf/llemulator/internal/script"
+)
+
+// TestRequestContextHonorsScriptTimeoutMs verifies requestContext derives a
+// context that's done once Script.TimeoutMs elapses, and not before.
+func TestRequestContextHonorsScriptTimeoutMs(t *testing.T) {
+	server := NewServer()
+	token := "timeout-ctx-token"
+	if err := server.engine.LoadScript(token, script.Script{Reset: true, TimeoutMs: 20}); err != nil {
+		t.Fatalf("LoadScript: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	ctx, cancel := server.requestContext(req, token)
+	defer cancel()
+
+	select {
+	case <-ctx.Done():
+		t.Fatal("context should not be done before TimeoutMs elapses")
+	default:
+	}
+
+	time.Sleep(40 * time.Millisecond)
+
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Errorf("got ctx.Err() = %v, want context.DeadlineExceeded", ctx.Err())
+	}
+}
+
+// TestRequestContextNoTimeoutConfigured verifies requestContext just
+// returns the request's own context, unmodified, when token's script set
+// no TimeoutMs.
+func TestRequestContextNoTimeoutConfigured(t *testing.T) {
+	server := NewServer()
+	req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+
+	ctx, cancel := server.requestContext(req, "no-script-token")
+	defer cancel()
+
+	if ctx != req.Context() {
+		t.Error("expected requestContext to return the request's own context unmodified")
+	}
+}
+
+// TestHandleOpenAIRequestDeadlineExceeded verifies a request whose context
+// is already done by the time its rule matched gets a deadline_exceeded
+// typed error instead of a delivered response, and that the matched rule
+// is not left consumed.
+func TestHandleOpenAIRequestDeadlineExceeded(t *testing.T) {
+	server := NewServer()
+	token := "deadline-exceeded-token"
+	if err := server.engine.LoadScript(token, script.Script{
+		Reset: true,
+		Rules: []script.Rule{
+			{
+				Match:    script.MatchRule{Method: "POST", Path: "/v1/chat/completions"},
+				Times:    1,
+				Response: script.ResponseRule{Status: 200, Content: "hi"},
+			},
+		},
+	}); err != nil {
+		t.Fatalf("LoadScript: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"model":    "gpt-4",
+		"messages": []map[string]interface{}{{"role": "user", "content": "hi"}},
+	})
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewReader(body)).WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+token)
+	recorder := httptest.NewRecorder()
+
+	server.HandleOpenAIRequest(recorder, req)
+
+	if recorder.Code != http.StatusGatewayTimeout {
+		t.Fatalf("got status %d, want %d", recorder.Code, http.StatusGatewayTimeout)
+	}
+
+	var errResp models.ErrorResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &errResp); err != nil {
+		t.Fatalf("failed to decode error response: %v", err)
+	}
+	if errResp.Error.Type != "deadline_exceeded" {
+		t.Errorf("got error type %q, want deadline_exceeded", errResp.Error.Type)
+	}
+
+	if _, remaining, ok := server.engine.RuleInfo(token, 0); !ok || remaining != 1 {
+		t.Errorf("expected the unreachable rule to remain unconsumed (Times=1), got remaining=%d ok=%v", remaining, ok)
+	}
+}
+
+// TestParseStreamDelayHeader verifies the X-Emulator-Stream-Delay header is
+// parsed as a non-negative integer, falling back to 0 (no override) for
+// anything else.
+func TestParseStreamDelayHeader(t *testing.T) {
+	cases := []struct {
+		value string
+		want  int
+	}{
+		{"", 0},
+		{"30", 30},
+		{"-5", 0},
+		{"not-a-number", 0},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		if c.value != "" {
+			req.Header.Set(streamDelayHeader, c.value)
+		}
+		if got := parseStreamDelayHeader(req); got != c.want {
+			t.Errorf("parseStreamDelayHeader(%q) = %d, want %d", c.value, got, c.want)
+		}
+	}
+}
+
+// TestWriteFormattedResponseStreamDelayOverridesTiming verifies a non-zero
+// streamDelayMs passed to writeFormattedResponse (as parsed from the
+// X-Emulator-Stream-Delay header) paces a streamed chat completion instead
+// of the rule's own Timing.
+func TestWriteFormattedResponseStreamDelayOverridesTiming(t *testing.T) {
+	server := NewServer()
+	recorder := httptest.NewRecorder()
+	reqBody, _ := json.Marshal(map[string]interface{}{"model": "gpt-4", "stream": true})
+	response := &script.ResponseRule{Content: "a b c", Timing: &script.Timing{InterTokenMs: 1}}
+
+	start := time.Now()
+	server.writeFormattedResponse(context.Background(), recorder, "", "/v1/chat/completions", reqBody, response, 30)
+	elapsed := time.Since(start)
+
+	if elapsed < 60*time.Millisecond {
+		t.Errorf("expected the header's 30ms override to apply across at least 2 gaps, elapsed was %v", elapsed)
+	}
+}
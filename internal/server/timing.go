@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/fabianvf/llemulator/internal/script"
+)
+
+// ErrSessionDeadline is the StreamResult.CancelCause used when a stream is
+// cut by a scripted session deadline (script.Timing.AbortAfterMs) rather
+// than by the request's own context being cancelled.
+var ErrSessionDeadline = errors.New("stream cancelled: session deadline exceeded")
+
+// streamPacing is the resolved, zero-value-safe form of script.Timing used
+// by the streaming writers; it exists so callers don't need to guard
+// against a nil *script.Timing at every call site.
+type streamPacing struct {
+	firstToken time.Duration
+	interToken time.Duration
+	jitter     time.Duration
+	stallAfter int
+}
+
+// applyInitialDelay folds a rule's ResponseRule.InitialDelayMs into its
+// Timing.FirstTokenMs so the two streaming writers, which only know about
+// *script.Timing, don't need their own separate initial-delay parameter.
+// It leaves timing untouched if initialDelayMs is zero or the timing
+// already specifies its own FirstTokenMs.
+func applyInitialDelay(timing *script.Timing, initialDelayMs int) *script.Timing {
+	if initialDelayMs <= 0 {
+		return timing
+	}
+	if timing == nil {
+		return &script.Timing{FirstTokenMs: initialDelayMs}
+	}
+	if timing.FirstTokenMs > 0 {
+		return timing
+	}
+	withDelay := *timing
+	withDelay.FirstTokenMs = initialDelayMs
+	return &withDelay
+}
+
+// applyStreamDelayOverride overrides timing's InterTokenMs with
+// streamDelayMs (parsed from the X-Emulator-Stream-Delay header) when it's
+// set. Unlike applyInitialDelay's fill-the-gap semantics, an explicit
+// header always wins over whatever the rule's own Timing specifies, since
+// it exists specifically to let a client inject pacing a script didn't ask
+// for.
+func applyStreamDelayOverride(timing *script.Timing, streamDelayMs int) *script.Timing {
+	if streamDelayMs <= 0 {
+		return timing
+	}
+	if timing == nil {
+		return &script.Timing{InterTokenMs: streamDelayMs}
+	}
+	withDelay := *timing
+	withDelay.InterTokenMs = streamDelayMs
+	return &withDelay
+}
+
+// requestContext derives the context a request against token should run
+// under: r's own context, further bounded by Script.TimeoutMs if one was
+// configured for token, the same way rate limits and embedding dims are
+// per-token script settings looked up by Engine. The returned cancel must
+// always be called by the caller, even when no deadline was configured.
+func (s *Server) requestContext(r *http.Request, token string) (context.Context, context.CancelFunc) {
+	timeoutMs := s.engine.RequestTimeoutMs(token)
+	if timeoutMs <= 0 {
+		return r.Context(), func() {}
+	}
+	return context.WithTimeout(r.Context(), time.Duration(timeoutMs)*time.Millisecond)
+}
+
+func resolvePacing(timing *script.Timing) streamPacing {
+	p := streamPacing{interToken: 10 * time.Millisecond}
+	if timing == nil {
+		return p
+	}
+	if timing.InterTokenMs > 0 {
+		p.interToken = time.Duration(timing.InterTokenMs) * time.Millisecond
+	}
+	p.firstToken = time.Duration(timing.FirstTokenMs) * time.Millisecond
+	p.jitter = time.Duration(timing.JitterMs) * time.Millisecond
+	p.stallAfter = timing.StallAfterTokens
+	return p
+}
+
+func (p streamPacing) delay() time.Duration {
+	d := p.interToken
+	if p.jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(p.jitter)))
+	}
+	return d
+}
+
+// sleepOrCancel blocks for d (if d > 0) or until ctx or cancelCh fires,
+// whichever comes first. It reports whether the sleep ran to completion; a
+// false return means the caller should treat the stream as cancelled and
+// consult ctx.Err() / cancelCh to tell the two causes apart.
+func sleepOrCancel(ctx context.Context, cancelCh <-chan struct{}, d time.Duration) bool {
+	var timerC <-chan time.Time
+	if d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timerC = timer.C
+	} else {
+		fired := make(chan time.Time, 1)
+		fired <- time.Now()
+		timerC = fired
+	}
+
+	select {
+	case <-timerC:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-cancelCh:
+		return false
+	}
+}
+
+// waitForever blocks until ctx or cancelCh fires, modeling a scripted
+// mid-stream stall (script.Timing.StallAfterTokens): no further tokens are
+// delivered, but the connection is left open until the client or script
+// gives up.
+func waitForever(ctx context.Context, cancelCh <-chan struct{}) {
+	select {
+	case <-ctx.Done():
+	case <-cancelCh:
+	}
+}
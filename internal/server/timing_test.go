@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabianvf/llemulator/internal/script"
+)
+
+// TestWriteChatCompletionStreamRespectsInterTokenTiming verifies that a
+// Timing.InterTokenMs value is honored instead of the writer's built-in
+// default delay.
+func TestWriteChatCompletionStreamRespectsInterTokenTiming(t *testing.T) {
+	server := NewServer()
+	recorder := httptest.NewRecorder()
+	req := map[string]interface{}{"model": "gpt-4"}
+	timing := &script.Timing{InterTokenMs: 30}
+
+	start := time.Now()
+	result := server.writeChatCompletionStream(context.Background(), recorder, "", "a b c", nil, req, timing)
+	elapsed := time.Since(start)
+
+	if result.Delivered != 3 {
+		t.Fatalf("expected 3 delivered chunks, got %d", result.Delivered)
+	}
+	if elapsed < 60*time.Millisecond {
+		t.Errorf("expected at least 2 inter-token delays of 30ms, elapsed was %v", elapsed)
+	}
+}
+
+// TestWriteChatCompletionStreamStallAfterTokens verifies that
+// StallAfterTokens stops delivering further content once reached, and that
+// the stream unblocks (as cancelled) once the request context is done.
+func TestWriteChatCompletionStreamStallAfterTokens(t *testing.T) {
+	server := NewServer()
+	recorder := httptest.NewRecorder()
+	req := map[string]interface{}{"model": "gpt-4"}
+	timing := &script.Timing{InterTokenMs: 1, StallAfterTokens: 2}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	result := server.writeChatCompletionStream(ctx, recorder, "", "one two three four five", nil, req, timing)
+
+	if result.Delivered != 2 {
+		t.Errorf("expected delivery to stop at 2 tokens, got %d", result.Delivered)
+	}
+	if !result.Cancelled {
+		t.Error("expected a stalled stream to report Cancelled once the context expires")
+	}
+}
+
+// TestWriteChatCompletionStreamAbortDeadline verifies that a session
+// deadline armed via script.Timing.AbortAfterMs cuts the stream even though
+// the request's own context is never cancelled.
+func TestWriteChatCompletionStreamAbortDeadline(t *testing.T) {
+	server := NewServer()
+	token := "abort-stream-token"
+
+	server.engine.LoadScript(token, script.Script{
+		Reset: true,
+		Rules: []script.Rule{
+			{
+				Match: script.MatchRule{Method: "POST", Path: "/v1/chat/completions"},
+				Times: 1,
+				Response: script.ResponseRule{
+					Status:  200,
+					Content: strings.Repeat("word ", 50),
+					Timing:  &script.Timing{AbortAfterMs: 20, InterTokenMs: 5},
+				},
+			},
+		},
+	})
+	response, err := server.engine.MatchRequest(token, "POST", "/v1/chat/completions", nil)
+	if err != nil {
+		t.Fatalf("failed to match request: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	req := map[string]interface{}{"model": "gpt-4"}
+	result := server.writeChatCompletionStream(context.Background(), recorder, token, response.Content, nil, req, response.Timing)
+
+	if !result.Cancelled {
+		t.Fatal("expected the session's abort deadline to cancel the stream")
+	}
+	if result.CancelCause != ErrSessionDeadline {
+		t.Errorf("expected CancelCause to be ErrSessionDeadline, got %v", result.CancelCause)
+	}
+	if result.Delivered >= 50 {
+		t.Errorf("expected the abort deadline to cut delivery short, got %d of 50 chunks", result.Delivered)
+	}
+
+	body := recorder.Body.String()
+	if strings.Contains(body, "[DONE]") {
+		t.Error("an aborted stream must never emit [DONE]")
+	}
+}
+
+// TestStreamScriptedSSERespectsPerEventDelay verifies that an SSEEvent's own
+// DelayMs overrides the writer's built-in default delay between events.
+func TestStreamScriptedSSERespectsPerEventDelay(t *testing.T) {
+	server := NewServer()
+	recorder := httptest.NewRecorder()
+	events := []script.SSEEvent{
+		{Data: json.RawMessage(`{"chunk": 1}`), DelayMs: 30},
+		{Data: json.RawMessage(`{"chunk": 2}`), DelayMs: 30},
+		{Data: json.RawMessage(`"[DONE]"`)},
+	}
+
+	start := time.Now()
+	server.streamScriptedSSE(context.Background(), recorder, events, 0, nil)
+	elapsed := time.Since(start)
+
+	if elapsed < 60*time.Millisecond {
+		t.Errorf("expected at least 2 per-event delays of 30ms, elapsed was %v", elapsed)
+	}
+}
+
+// TestStreamScriptedSSEJitterStaysWithinBounds verifies that an SSEEvent's
+// JitterMs only ever adds, never exceeds, its bound on top of DelayMs.
+func TestStreamScriptedSSEJitterStaysWithinBounds(t *testing.T) {
+	server := NewServer()
+	const delayMs, jitterMs = 10, 20
+
+	for i := 0; i < 20; i++ {
+		recorder := httptest.NewRecorder()
+		events := []script.SSEEvent{
+			{Data: json.RawMessage(`{"chunk": 1}`), DelayMs: delayMs, JitterMs: jitterMs},
+		}
+
+		start := time.Now()
+		server.streamScriptedSSE(context.Background(), recorder, events, 0, nil)
+		elapsed := time.Since(start)
+
+		if elapsed < delayMs*time.Millisecond {
+			t.Fatalf("elapsed %v was under the DelayMs floor of %dms", elapsed, delayMs)
+		}
+		if elapsed > (delayMs+jitterMs+20)*time.Millisecond {
+			t.Fatalf("elapsed %v exceeded DelayMs+JitterMs bound of %dms by more than scheduling slack", elapsed, delayMs+jitterMs)
+		}
+	}
+}
+
+// TestStreamScriptedSSEInitialDelay verifies that InitialDelayMs delays the
+// first event without affecting the per-event pacing of the rest.
+func TestStreamScriptedSSEInitialDelay(t *testing.T) {
+	server := NewServer()
+	recorder := httptest.NewRecorder()
+	events := []script.SSEEvent{{Data: json.RawMessage(`{"chunk": 1}`)}}
+
+	start := time.Now()
+	server.streamScriptedSSE(context.Background(), recorder, events, 40, nil)
+	elapsed := time.Since(start)
+
+	if elapsed < 40*time.Millisecond {
+		t.Errorf("expected InitialDelayMs to delay the first event by at least 40ms, elapsed was %v", elapsed)
+	}
+}
+
+// TestStreamScriptedSSEFailAfterEvents verifies that FailAfter.Events
+// truncates the stream after that many events instead of completing
+// normally, without writing a [DONE] frame.
+func TestStreamScriptedSSEFailAfterEvents(t *testing.T) {
+	server := NewServer()
+	recorder := httptest.NewRecorder()
+	events := []script.SSEEvent{
+		{Data: json.RawMessage(`{"chunk": 1}`)},
+		{Data: json.RawMessage(`{"chunk": 2}`)},
+		{Data: json.RawMessage(`{"chunk": 3}`)},
+		{Data: json.RawMessage(`"[DONE]"`)},
+	}
+
+	server.streamScriptedSSE(context.Background(), recorder, events, 0, &script.FailureSpec{Events: 2, Kind: script.FaultServerError, Status: 503})
+
+	body := recorder.Body.String()
+	if strings.Contains(body, `"chunk": 3`) || strings.Contains(body, "[DONE]") {
+		t.Errorf("expected the stream to stop after 2 events, got body: %q", body)
+	}
+	if !strings.Contains(body, "server_error") {
+		t.Errorf("expected a scripted server_error frame after truncation, got body: %q", body)
+	}
+}
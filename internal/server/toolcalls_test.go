@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fabianvf/llemulator/internal/models"
+)
+
+// TestWriteChatCompletionIncludesToolCalls verifies the non-streaming path
+// emits tool_calls on the assistant message with finish_reason "tool_calls".
+func TestWriteChatCompletionIncludesToolCalls(t *testing.T) {
+	server := NewServer()
+	recorder := httptest.NewRecorder()
+	toolCalls := []models.ToolCall{
+		{ID: "call_1", Type: "function", Function: models.ToolCallFunction{Name: "get_weather", Arguments: `{"location":"Boston"}`}},
+	}
+
+	server.writeChatCompletion(recorder, "", toolCalls, map[string]interface{}{"model": "gpt-4"}, 200)
+
+	var completion models.ChatCompletion
+	if err := json.Unmarshal(recorder.Body.Bytes(), &completion); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	choice := completion.Choices[0]
+	if choice.FinishReason == nil || *choice.FinishReason != "tool_calls" {
+		t.Fatalf("expected finish_reason tool_calls, got %v", choice.FinishReason)
+	}
+	if len(choice.Message.ToolCalls) != 1 || choice.Message.ToolCalls[0].Function.Name != "get_weather" {
+		t.Fatalf("expected the tool call to round-trip, got %+v", choice.Message.ToolCalls)
+	}
+}
+
+// TestWriteChatCompletionStreamEmitsToolCallFragments verifies the streaming
+// path fragments a tool call's arguments across several delta.tool_calls
+// chunks, then finishes with finish_reason "tool_calls".
+func TestWriteChatCompletionStreamEmitsToolCallFragments(t *testing.T) {
+	server := NewServer()
+	recorder := httptest.NewRecorder()
+	toolCalls := []models.ToolCall{
+		{ID: "call_1", Type: "function", Function: models.ToolCallFunction{Name: "get_weather", Arguments: `{"location":"Boston"}`}},
+	}
+
+	result := server.writeChatCompletionStream(context.Background(), recorder, "", "", toolCalls, map[string]interface{}{"model": "gpt-4"}, nil)
+	if result.Cancelled {
+		t.Fatal("expected an uncancelled stream")
+	}
+
+	var sawName, sawFinish bool
+	var reassembled string
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		if !strings.HasPrefix(line, "data: ") || line == "data: [DONE]" {
+			continue
+		}
+		var chunk models.ChatCompletion
+		if err := json.Unmarshal([]byte(line[len("data: "):]), &chunk); err != nil {
+			continue
+		}
+		delta := chunk.Choices[0].Delta
+		if len(delta.ToolCalls) > 0 {
+			tc := delta.ToolCalls[0]
+			reassembled += tc.Function.Arguments
+			if tc.Function.Name == "get_weather" {
+				sawName = true
+			}
+		}
+		if chunk.Choices[0].FinishReason != nil && *chunk.Choices[0].FinishReason == "tool_calls" {
+			sawFinish = true
+		}
+	}
+
+	if !sawName {
+		t.Error("expected the first tool call fragment to carry the function name")
+	}
+	if !sawFinish {
+		t.Error("expected a finish chunk with finish_reason tool_calls")
+	}
+	if reassembled != `{"location":"Boston"}` {
+		t.Errorf("expected reassembled fragments to equal the original arguments, got %q", reassembled)
+	}
+}
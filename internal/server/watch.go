@@ -0,0 +1,220 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// WatchEventType identifies the kind of per-token lifecycle event GET
+// /_emulator/watch reports.
+type WatchEventType string
+
+const (
+	WatchScriptLoaded     WatchEventType = "script_loaded"
+	WatchResponseConsumed WatchEventType = "response_consumed"
+	WatchScriptExhausted  WatchEventType = "script_exhausted"
+	WatchErrorReturned    WatchEventType = "error_returned"
+)
+
+// WatchEvent is one entry in a token's watch stream. Cursor is a
+// monotonically increasing, per-token sequence number a client can echo
+// back as ?from= on reconnect to resume without missing events buffered
+// while it was disconnected.
+type WatchEvent struct {
+	Cursor int64          `json:"cursor"`
+	Type   WatchEventType `json:"type"`
+	Time   time.Time      `json:"time"`
+
+	// RuleIndex, Pattern, and Remaining describe a response_consumed
+	// event: which rule answered the request, identified by Pattern (or
+	// "METHOD path" if the rule has no Pattern — see script.Engine.RuleInfo),
+	// and how many Times it has left.
+	RuleIndex *int   `json:"rule_index,omitempty"`
+	Pattern   string `json:"pattern,omitempty"`
+	Remaining *int   `json:"remaining,omitempty"`
+
+	// ErrorType and Status describe an error_returned event, mirroring
+	// the type/status an OpenAI-shaped error body would carry.
+	ErrorType string `json:"error_type,omitempty"`
+	Status    int    `json:"status,omitempty"`
+}
+
+// watchRingSize bounds how many past events a disconnected watcher can
+// recover via ?from=; older events are evicted like audit.Log's entries.
+const watchRingSize = 64
+
+// watchBuffer is one token's ring of recent WatchEvents plus its live SSE
+// subscribers.
+type watchBuffer struct {
+	mu         sync.Mutex
+	events     []WatchEvent
+	nextCursor int64
+	subs       map[chan WatchEvent]struct{}
+}
+
+// emit appends evt to the ring (assigning it the next cursor, evicting the
+// oldest event if full) and fans it out to every live subscriber. A
+// subscriber whose channel is full is skipped rather than blocking the
+// request that triggered the event; it can recover the event on reconnect
+// via ?from=.
+func (b *watchBuffer) emit(evt WatchEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextCursor++
+	evt.Cursor = b.nextCursor
+
+	b.events = append(b.events, evt)
+	if len(b.events) > watchRingSize {
+		b.events = b.events[len(b.events)-watchRingSize:]
+	}
+
+	for ch := range b.subs {
+		select {
+		case ch <- evt:
+		default:
+		}
+	}
+}
+
+// subscribe registers a new live subscriber and returns the events still
+// in the ring with a cursor greater than from. Both happen under the same
+// lock as emit, so an event emitted concurrently with this call is either
+// in the returned backlog or delivered on the channel, never both and
+// never neither.
+func (b *watchBuffer) subscribe(from int64) (chan WatchEvent, []WatchEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var backlog []WatchEvent
+	for _, evt := range b.events {
+		if evt.Cursor > from {
+			backlog = append(backlog, evt)
+		}
+	}
+
+	ch := make(chan WatchEvent, 16)
+	if b.subs == nil {
+		b.subs = make(map[chan WatchEvent]struct{})
+	}
+	b.subs[ch] = struct{}{}
+	return ch, backlog
+}
+
+// unsubscribe removes and closes ch. It's a no-op if ch was already
+// removed (e.g. by a previous call).
+func (b *watchBuffer) unsubscribe(ch chan WatchEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// watchHub owns one watchBuffer per token, created lazily, the same way
+// script.Engine keeps one SessionState per token.
+type watchHub struct {
+	mu      sync.Mutex
+	buffers map[string]*watchBuffer
+}
+
+func newWatchHub() *watchHub {
+	return &watchHub{buffers: make(map[string]*watchBuffer)}
+}
+
+func (h *watchHub) bufferFor(token string) *watchBuffer {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	b, ok := h.buffers[token]
+	if !ok {
+		b = &watchBuffer{}
+		h.buffers[token] = b
+	}
+	return b
+}
+
+// emit is the convenience form of bufferFor(token).emit(evt) used by every
+// call site that just wants to record an event, not hold onto the buffer.
+func (h *watchHub) emit(token string, evt WatchEvent) {
+	h.bufferFor(token).emit(evt)
+}
+
+// emitRuleConsumed emits a response_consumed event describing rule idx of
+// token's loaded script, followed by a script_exhausted event if that
+// consumption left every rule in the script used up. It's a no-op if idx
+// doesn't resolve to a rule (e.g. the request was answered some other way
+// than rule matching).
+func (s *Server) emitRuleConsumed(token string, idx int) {
+	pattern, remaining, ok := s.engine.RuleInfo(token, idx)
+	if !ok {
+		return
+	}
+
+	ruleIndex, remainingCopy := idx, remaining
+	s.watch.emit(token, WatchEvent{
+		Type:      WatchResponseConsumed,
+		Time:      time.Now(),
+		RuleIndex: &ruleIndex,
+		Pattern:   pattern,
+		Remaining: &remainingCopy,
+	})
+
+	if remaining <= 0 && s.engine.Exhausted(token) {
+		s.watch.emit(token, WatchEvent{Type: WatchScriptExhausted, Time: time.Now()})
+	}
+}
+
+// HandleWatch opens a long-lived Server-Sent Events stream of WatchEvents
+// for the caller's token — script_loaded, response_consumed,
+// script_exhausted, and error_returned — so an integration test can
+// synchronize on script consumption instead of polling /_emulator/state.
+// It honors r.Context().Done() so a client disconnect stops the handler,
+// and a client that reconnects with ?from=<cursor> (the last cursor it
+// saw) picks up from there instead of missing events emitted in the gap.
+func (s *Server) HandleWatch(w http.ResponseWriter, r *http.Request) {
+	token := extractToken(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "Missing or invalid authorization", "auth_error", nil, nil)
+		return
+	}
+
+	var from int64
+	if v := r.URL.Query().Get("from"); v != "" {
+		from, _ = strconv.ParseInt(v, 10, 64)
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	setSSEHeaders(w)
+
+	buffer := s.watch.bufferFor(token)
+	ch, backlog := buffer.subscribe(from)
+	defer buffer.unsubscribe(ch)
+
+	write := func(evt WatchEvent) {
+		data, _ := json.Marshal(evt)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+
+	for _, evt := range backlog {
+		write(evt)
+	}
+
+	for {
+		select {
+		case evt := <-ch:
+			write(evt)
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
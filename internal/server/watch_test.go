@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabianvf/llemulator/internal/script"
+)
+
+// TestWatchBufferEmitAssignsSequentialCursors verifies cursors are assigned
+// in emit order starting from 1, independent of the event's own fields.
+func TestWatchBufferEmitAssignsSequentialCursors(t *testing.T) {
+	buffer := &watchBuffer{}
+
+	buffer.emit(WatchEvent{Type: WatchScriptLoaded})
+	buffer.emit(WatchEvent{Type: WatchResponseConsumed})
+
+	if buffer.events[0].Cursor != 1 || buffer.events[1].Cursor != 2 {
+		t.Fatalf("got cursors %d, %d; want 1, 2", buffer.events[0].Cursor, buffer.events[1].Cursor)
+	}
+}
+
+// TestWatchBufferEvictsOldestWhenFull verifies the ring drops the oldest
+// event rather than growing without bound.
+func TestWatchBufferEvictsOldestWhenFull(t *testing.T) {
+	buffer := &watchBuffer{}
+
+	for i := 0; i < watchRingSize+1; i++ {
+		buffer.emit(WatchEvent{Type: WatchScriptLoaded})
+	}
+
+	if len(buffer.events) != watchRingSize {
+		t.Fatalf("got %d buffered events, want %d", len(buffer.events), watchRingSize)
+	}
+	if buffer.events[0].Cursor != 2 {
+		t.Errorf("expected the first event (cursor 1) to have been evicted, oldest remaining cursor is %d", buffer.events[0].Cursor)
+	}
+}
+
+// TestWatchBufferSubscribeReplaysOnlyNewerEvents verifies subscribe(from)
+// returns only events with a cursor greater than from.
+func TestWatchBufferSubscribeReplaysOnlyNewerEvents(t *testing.T) {
+	buffer := &watchBuffer{}
+	buffer.emit(WatchEvent{Type: WatchScriptLoaded})
+	buffer.emit(WatchEvent{Type: WatchResponseConsumed})
+	buffer.emit(WatchEvent{Type: WatchScriptExhausted})
+
+	ch, backlog := buffer.subscribe(1)
+	defer buffer.unsubscribe(ch)
+
+	if len(backlog) != 2 || backlog[0].Type != WatchResponseConsumed || backlog[1].Type != WatchScriptExhausted {
+		t.Fatalf("got backlog %+v; want the two events after cursor 1", backlog)
+	}
+}
+
+// TestWatchBufferEmitFansOutToLiveSubscribers verifies a subscriber
+// receives events emitted after it subscribes.
+func TestWatchBufferEmitFansOutToLiveSubscribers(t *testing.T) {
+	buffer := &watchBuffer{}
+	ch, backlog := buffer.subscribe(0)
+	defer buffer.unsubscribe(ch)
+
+	if len(backlog) != 0 {
+		t.Fatalf("expected no backlog for a fresh buffer, got %+v", backlog)
+	}
+
+	buffer.emit(WatchEvent{Type: WatchScriptLoaded})
+
+	select {
+	case evt := <-ch:
+		if evt.Type != WatchScriptLoaded {
+			t.Errorf("got event type %q, want %q", evt.Type, WatchScriptLoaded)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("subscriber did not receive the emitted event in time")
+	}
+}
+
+// TestHandleWatchRequiresAuth verifies HandleWatch rejects a request with no
+// bearer token, matching HandleScript, HandleReset and HandleState.
+func TestHandleWatchRequiresAuth(t *testing.T) {
+	server := NewServer()
+	req := httptest.NewRequest("GET", "/_emulator/watch", nil)
+	recorder := httptest.NewRecorder()
+
+	server.HandleWatch(recorder, req)
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d, want %d", recorder.Code, http.StatusUnauthorized)
+	}
+}
+
+// TestHandleWatchStreamsEventsAndStopsOnContextDone verifies a subscriber
+// sees a script_loaded event emitted after it connects, and that the
+// handler returns once the request context is cancelled.
+func TestHandleWatchStreamsEventsAndStopsOnContextDone(t *testing.T) {
+	server := NewServer()
+	token := "watch-test-token"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/_emulator/watch", nil).WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+token)
+	recorder := httptest.NewRecorder()
+
+	doneCh := make(chan struct{})
+	go func() {
+		server.HandleWatch(recorder, req)
+		close(doneCh)
+	}()
+
+	// Give HandleWatch time to subscribe before the event is emitted, so it
+	// must arrive via the live channel rather than the backlog.
+	time.Sleep(20 * time.Millisecond)
+	server.watch.emit(token, WatchEvent{Type: WatchScriptLoaded})
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatal("HandleWatch did not exit within a bound after context cancellation")
+	}
+
+	if !strings.Contains(recorder.Body.String(), `"script_loaded"`) {
+		t.Fatalf("expected a script_loaded event in the stream, got body: %q", recorder.Body.String())
+	}
+}
+
+// TestHandleWatchFromReplaysBacklog verifies a reconnect with ?from=<cursor>
+// replays events the client missed while disconnected instead of only
+// delivering events emitted after it reconnects.
+func TestHandleWatchFromReplaysBacklog(t *testing.T) {
+	server := NewServer()
+	token := "watch-replay-token"
+
+	server.watch.emit(token, WatchEvent{Type: WatchScriptLoaded})
+	server.watch.emit(token, WatchEvent{Type: WatchResponseConsumed})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest("GET", "/_emulator/watch?from=1", nil).WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+token)
+	recorder := httptest.NewRecorder()
+
+	server.HandleWatch(recorder, req)
+
+	body := recorder.Body.String()
+	if strings.Contains(body, `"script_loaded"`) {
+		t.Errorf("expected the event at cursor 1 to be excluded by ?from=1, got body: %q", body)
+	}
+	if !strings.Contains(body, `"response_consumed"`) {
+		t.Errorf("expected the event at cursor 2 to be replayed, got body: %q", body)
+	}
+}
+
+// TestEmitRuleConsumedEmitsExhaustedAfterLastUse verifies emitRuleConsumed
+// follows a response_consumed event with script_exhausted once every rule
+// in the script has run out of uses.
+func TestEmitRuleConsumedEmitsExhaustedAfterLastUse(t *testing.T) {
+	server := NewServer()
+	token := "watch-exhaust-token"
+
+	server.engine.LoadScript(token, script.Script{
+		Reset: true,
+		Rules: []script.Rule{
+			{
+				Match:    script.MatchRule{Method: "POST", Path: "/v1/chat/completions"},
+				Times:    1,
+				Response: script.ResponseRule{Status: 200, Content: "hi"},
+			},
+		},
+	})
+	if _, _, err := server.engine.MatchRequestWithIndex(token, "POST", "/v1/chat/completions", nil, nil); err != nil {
+		t.Fatalf("MatchRequestWithIndex: %v", err)
+	}
+
+	ch, backlog := server.watch.bufferFor(token).subscribe(0)
+	defer server.watch.bufferFor(token).unsubscribe(ch)
+	if len(backlog) != 0 {
+		t.Fatalf("expected no events recorded yet, got %+v", backlog)
+	}
+
+	server.emitRuleConsumed(token, 0)
+
+	var got []WatchEventType
+	for len(got) < 2 {
+		select {
+		case evt := <-ch:
+			got = append(got, evt.Type)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for events, got %v so far", got)
+		}
+	}
+
+	if got[0] != WatchResponseConsumed || got[1] != WatchScriptExhausted {
+		t.Fatalf("got event types %v; want [response_consumed script_exhausted]", got)
+	}
+}
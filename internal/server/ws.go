@@ -0,0 +1,168 @@
+//go:build websocket
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fabianvf/llemulator/internal/models"
+	"github.com/gorilla/websocket"
+)
+
+// wsPongWait/wsPingPeriod mirror the usual gorilla/websocket keep-alive
+// dance: the server pings well inside the deadline it expects a pong back
+// within.
+const (
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = 20 * time.Second
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+func init() {
+	registerWebSocketRoute = func(s *Server, mux *http.ServeMux) {
+		mux.HandleFunc("/v1/chat/completions/ws", s.handleChatCompletionsWS)
+	}
+}
+
+// handleChatCompletionsWS upgrades to a WebSocket and streams the same
+// chunk sequence produced by generateChunks, one {"type":"chunk"} message
+// per chunk, followed by a finish chunk and a final {"type":"done"} frame
+// -- the same role/content/finish/done ordering the SSE transport writes.
+// A client-sent close frame (or any read error) cancels generation via
+// ctx, the same cancellation path the SSE transport uses.
+func (s *Server) handleChatCompletionsWS(w http.ResponseWriter, r *http.Request) {
+	token := extractToken(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "Missing or invalid authorization", "auth_error", nil, nil)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// gorilla/websocket only supports one concurrent writer per Conn, but
+	// the ping ticker below and the chunk loop both write to conn from
+	// separate goroutines; writeMu serializes every write to the socket.
+	var writeMu sync.Mutex
+	writeJSON := func(v interface{}) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteJSON(v)
+	}
+	writeMessage := func(messageType int, data []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteMessage(messageType, data)
+	}
+
+	// The chat completion request itself arrives as the first WS message
+	// rather than the upgrade request's body, since WS clients (browsers
+	// included) can't reliably attach a body to the upgrade handshake.
+	_, body, err := conn.ReadMessage()
+	if err != nil {
+		return
+	}
+
+	var req map[string]interface{}
+	json.Unmarshal(body, &req)
+
+	model := "gpt-4"
+	if m, ok := req["model"].(string); ok {
+		model = m
+	}
+
+	// Match against the chat completions path the WS transport emulates,
+	// not r.URL.Path (the "/v1/chat/completions/ws" upgrade endpoint
+	// itself), since that's the path every loaded script's rules are
+	// written against.
+	response, err := s.engine.MatchRequest(token, http.MethodPost, "/v1/chat/completions", body)
+	if err != nil {
+		writeJSON(map[string]interface{}{"type": "error", "error": err.Error()})
+		return
+	}
+
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		return nil
+	})
+
+	// A read error (including a client close frame, which surfaces as
+	// one) cancels generation; other client messages are ignored.
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(wsPingPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := writeMessage(websocket.PingMessage, nil); err != nil {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	id := generateID("chatcmpl")
+	for chunk := range generateChunks(ctx, id, model, response.Content) {
+		if err := writeJSON(map[string]interface{}{
+			"type":  "chunk",
+			"chunk": chunk,
+		}); err != nil {
+			return
+		}
+	}
+
+	finishReason := "stop"
+	if ctx.Err() != nil {
+		finishReason = "cancelled"
+	}
+	finishChunk := models.ChatCompletion{
+		ID:      id,
+		Object:  "chat.completion.chunk",
+		Created: time.Now().Unix(),
+		Model:   model,
+		Choices: []models.ChatChoice{
+			{
+				Index:        0,
+				Delta:        &models.ChatMessage{},
+				FinishReason: stringPtr(finishReason),
+			},
+		},
+	}
+	if err := writeJSON(map[string]interface{}{
+		"type":  "chunk",
+		"chunk": finishChunk,
+	}); err != nil {
+		return
+	}
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	writeJSON(map[string]interface{}{"type": "done"})
+}
@@ -0,0 +1,141 @@
+//go:build websocket
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func loadWSScript(t *testing.T, baseURL, token string, scriptBody []byte) {
+	t.Helper()
+	req, _ := http.NewRequest("POST", baseURL+"/_emulator/script", bytes.NewReader(scriptBody))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if _, err := http.DefaultClient.Do(req); err != nil {
+		t.Fatalf("failed to load script: %v", err)
+	}
+}
+
+// TestWSFrameOrdering verifies the WebSocket transport emits the same
+// role -> content -> finish -> done sequence that the SSE transport's
+// TestStreamCompletion checks for, just carried as discrete JSON messages
+// instead of "data: " lines.
+func TestWSFrameOrdering(t *testing.T) {
+	srv := NewServer()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	token := "ws-test"
+	scriptBody, _ := json.Marshal(map[string]interface{}{
+		"reset":     true,
+		"responses": "hello world",
+	})
+	loadWSScript(t, ts.URL, token, scriptBody)
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/v1/chat/completions/ws"
+	header := map[string][]string{"Authorization": {"Bearer " + token}}
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, header)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	chatBody, _ := json.Marshal(map[string]interface{}{
+		"model": "gpt-4",
+		"messages": []map[string]interface{}{
+			{"role": "user", "content": "hi"},
+		},
+	})
+	if err := conn.WriteMessage(websocket.TextMessage, chatBody); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	var sawRole, sawContent, sawFinish, sawDone bool
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for !sawDone {
+		var msg map[string]interface{}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("read failed before done frame: %v", err)
+		}
+
+		switch msg["type"] {
+		case "chunk":
+			chunk := msg["chunk"].(map[string]interface{})
+			choice := chunk["choices"].([]interface{})[0].(map[string]interface{})
+			delta := choice["delta"].(map[string]interface{})
+			if finishReason, ok := choice["finish_reason"].(string); ok && finishReason != "" {
+				if !sawContent {
+					t.Fatal("finish frame arrived before any content frame")
+				}
+				sawFinish = true
+				continue
+			}
+			if role, ok := delta["role"].(string); ok && role != "" {
+				sawRole = true
+				continue
+			}
+			if sawRole {
+				sawContent = true
+			} else {
+				t.Fatal("content frame arrived before role frame")
+			}
+		case "done":
+			if !sawFinish {
+				t.Fatal("done frame arrived before finish frame")
+			}
+			sawDone = true
+		}
+	}
+
+	if !sawRole || !sawContent || !sawFinish || !sawDone {
+		t.Fatalf("missing frame(s): role=%v content=%v finish=%v done=%v", sawRole, sawContent, sawFinish, sawDone)
+	}
+}
+
+// TestWSCancellationOnClose verifies that closing the client connection
+// stops the server from blocking on generateChunks forever.
+func TestWSCancellationOnClose(t *testing.T) {
+	srv := NewServer()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	token := "ws-cancel-test"
+	scriptBody, _ := json.Marshal(map[string]interface{}{
+		"reset":     true,
+		"responses": strings.Repeat("word ", 500),
+	})
+	loadWSScript(t, ts.URL, token, scriptBody)
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/v1/chat/completions/ws"
+	header := map[string][]string{"Authorization": {"Bearer " + token}}
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, header)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+
+	chatBody, _ := json.Marshal(map[string]interface{}{
+		"model": "gpt-4",
+		"messages": []map[string]interface{}{
+			{"role": "user", "content": "hi"},
+		},
+	})
+	if err := conn.WriteMessage(websocket.TextMessage, chatBody); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	// Read a single frame, then close the connection; the server should
+	// notice via its read goroutine and stop streaming promptly.
+	var msg map[string]interface{}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	conn.Close()
+}
@@ -1,84 +1,386 @@
 package session
 
 import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"io"
 	"sync"
+	"time"
 )
 
 // Session represents a user session with isolated state
 type Session struct {
-	mu   sync.Mutex
-	data map[string]interface{}
+	mu         sync.Mutex
+	data       map[string]interface{}
+	lastAccess time.Time
+
+	// store and token let a mutation made through Execute/ExecuteWithData/
+	// SetData/Clear flush itself back to whatever SessionStore it came
+	// from, instead of only ever being persisted once at creation. Manager
+	// sets these via attach whenever it returns a session to a caller.
+	// They're nil for a Session built directly (e.g. in tests), in which
+	// case persist is a no-op.
+	store SessionStore
+	token string
+}
+
+// attach records the SessionStore and token a session was loaded from, so
+// its own mutating methods can persist themselves (see persist). It's a
+// no-op after the first call, since a session's store/token pair is fixed
+// for its lifetime.
+func (s *Session) attach(store SessionStore, token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.store == nil {
+		s.store = store
+		s.token = token
+	}
+}
+
+// persist flushes the session back to its attached store, if any. Called
+// after every mutating method so FileStore/RedisStore-backed sessions
+// (whose Load reconstructs a fresh *Session from the on-disk/Redis blob
+// rather than returning a live pointer) don't silently lose mutations made
+// between one request and the next.
+func (s *Session) persist() {
+	s.mu.Lock()
+	store, token := s.store, s.token
+	s.mu.Unlock()
+
+	if store != nil {
+		store.Save(token, s)
+	}
+}
+
+// ManagerOptions configures the TTL/LRU/reaper behavior of a Manager created
+// via NewManagerWithOptions. A zero value for any field disables that
+// behavior (no TTL, no cap, no background reaping).
+type ManagerOptions struct {
+	// IdleTTL evicts a session once it has gone this long without an access.
+	IdleTTL time.Duration
+	// MaxSessions caps the number of live sessions; the least-recently-used
+	// session is evicted to make room for a new one.
+	MaxSessions int
+	// ReapInterval controls how often the background janitor walks the
+	// session map looking for idle sessions to evict. Ignored if IdleTTL is 0.
+	ReapInterval time.Duration
 }
 
-// Manager handles session creation and lifecycle
+// Manager handles session creation and lifecycle. Storage is delegated to a
+// SessionStore; Manager itself only tracks LRU order and drives TTL reaping.
 type Manager struct {
 	mu       sync.RWMutex
-	sessions map[string]*Session
+	store    SessionStore
+	lru      *list.List            // front = most recently used, back = least recently used
+	lruIndex map[string]*list.Element
+
+	opts     ManagerOptions
+	stopReap chan struct{}
+	reapDone chan struct{}
+}
+
+// lruEntry is the value stored in Manager.lru elements.
+type lruEntry struct {
+	token   string
+	session *Session
 }
 
-// NewManager creates a new session manager
+// NewManager creates a new session manager backed by an InMemoryStore, with
+// no TTL, eviction cap, or background reaper.
 func NewManager() *Manager {
-	return &Manager{
-		sessions: make(map[string]*Session),
+	return NewManagerWithStore(NewInMemoryStore(), ManagerOptions{})
+}
+
+// NewManagerWithOptions creates an InMemoryStore-backed session manager that
+// evicts idle sessions and/or caps the number of live sessions via LRU
+// eviction. If opts.IdleTTL and opts.ReapInterval are both set, a background
+// goroutine is started to reap idle sessions; call Close to stop it.
+func NewManagerWithOptions(opts ManagerOptions) *Manager {
+	return NewManagerWithStore(NewInMemoryStore(), opts)
+}
+
+// NewManagerWithStore creates a session manager backed by the given
+// SessionStore, e.g. a FileStore or RedisStore for persistence across
+// restarts or across replicas.
+func NewManagerWithStore(store SessionStore, opts ManagerOptions) *Manager {
+	m := &Manager{
+		store:    store,
+		lru:      list.New(),
+		lruIndex: make(map[string]*list.Element),
+		opts:     opts,
+	}
+
+	if opts.IdleTTL > 0 && opts.ReapInterval > 0 {
+		m.stopReap = make(chan struct{})
+		m.reapDone = make(chan struct{})
+		go m.reapLoop()
+	}
+
+	return m
+}
+
+func (m *Manager) reapLoop() {
+	defer close(m.reapDone)
+
+	ticker := time.NewTicker(m.opts.ReapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.reapIdle()
+		case <-m.stopReap:
+			return
+		}
+	}
+}
+
+func (m *Manager) reapIdle() {
+	var expired []string
+	m.store.Range(func(token string, session *Session) bool {
+		session.mu.Lock()
+		idle := time.Since(session.lastAccess)
+		session.mu.Unlock()
+
+		if idle > m.opts.IdleTTL {
+			expired = append(expired, token)
+		}
+		return true
+	})
+
+	if len(expired) == 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, token := range expired {
+		m.removeLocked(token)
 	}
 }
 
 // GetOrCreateSession retrieves existing session or creates new one
 func (m *Manager) GetOrCreateSession(token string) *Session {
-	m.mu.RLock()
-	session, exists := m.sessions[token]
-	m.mu.RUnlock()
-	
-	if exists {
+	if session, exists := m.store.Load(token); exists {
+		session.attach(m.store, token)
+		m.touch(token, session)
 		return session
 	}
-	
-	// Create new session
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	
+
 	// Double-check after acquiring write lock
-	if session, exists = m.sessions[token]; exists {
+	if session, exists := m.store.Load(token); exists {
+		session.attach(m.store, token)
+		m.touchLocked(token, session)
 		return session
 	}
-	
-	session = &Session{
-		data: make(map[string]interface{}),
+
+	if m.opts.MaxSessions > 0 && len(m.lruIndex) >= m.opts.MaxSessions {
+		m.evictLRULocked()
+	}
+
+	session := &Session{
+		data:       make(map[string]interface{}),
+		lastAccess: time.Now(),
 	}
-	m.sessions[token] = session
-	
+	session.attach(m.store, token)
+	m.store.Save(token, session)
+	m.lruIndex[token] = m.lru.PushFront(&lruEntry{token: token, session: session})
+
 	return session
 }
 
+// evictLRULocked removes the least-recently-used session. m.mu must be held.
+func (m *Manager) evictLRULocked() {
+	back := m.lru.Back()
+	if back == nil {
+		return
+	}
+	entry := back.Value.(*lruEntry)
+	m.removeLocked(entry.token)
+}
+
+// removeLocked deletes a session from both the store and the LRU list. m.mu
+// must be held for writing.
+func (m *Manager) removeLocked(token string) {
+	m.store.Delete(token)
+	if element, ok := m.lruIndex[token]; ok {
+		m.lru.Remove(element)
+		delete(m.lruIndex, token)
+	}
+}
+
+// touch records an access against a session and moves it to the front of the
+// LRU list, acquiring Manager.mu itself.
+func (m *Manager) touch(token string, session *Session) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.touchLocked(token, session)
+}
+
+// touchLocked is touch's implementation; m.mu must already be held.
+func (m *Manager) touchLocked(token string, session *Session) {
+	session.mu.Lock()
+	session.lastAccess = time.Now()
+	session.mu.Unlock()
+
+	if element, ok := m.lruIndex[token]; ok {
+		m.lru.MoveToFront(element)
+	}
+}
+
 // GetSession retrieves a session without creating
 func (m *Manager) GetSession(token string) *Session {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	return m.sessions[token]
+	session, exists := m.store.Load(token)
+	if exists {
+		session.attach(m.store, token)
+		m.touch(token, session)
+	}
+	return session
 }
 
 // ResetSession clears all data for a token
 func (m *Manager) ResetSession(token string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	
-	delete(m.sessions, token)
+
+	m.removeLocked(token)
+}
+
+// snapshotEntry is the JSON-serializable form of one session: its token
+// and its data map (set via SetData/GetData). This is where a caller
+// that associates a loaded script with a session — by storing it under a
+// well-known data key — keeps whatever it wants to survive a
+// Snapshot/Restore round trip; Manager itself has no knowledge of
+// scripts.
+type snapshotEntry struct {
+	Token string                 `json:"token"`
+	Data  map[string]interface{} `json:"data"`
+}
+
+// Snapshot writes every live session's token and data map to w as a JSON
+// array, so a long-running test suite can persist emulator state across
+// a restart and reload it with Restore. Each session's data map is
+// copied under the session's own lock while Manager's read lock is held
+// just long enough to enumerate sessions; the JSON encoding itself
+// happens after both locks are released, so Snapshot never blocks (or is
+// blocked by) live traffic for longer than the copy takes.
+func (m *Manager) Snapshot(w io.Writer) error {
+	entries := m.collectSnapshot()
+
+	encoded, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("session: encoding snapshot: %w", err)
+	}
+
+	_, err = w.Write(encoded)
+	return err
+}
+
+func (m *Manager) collectSnapshot() []snapshotEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var entries []snapshotEntry
+	m.store.Range(func(token string, sess *Session) bool {
+		sess.mu.Lock()
+		data := make(map[string]interface{}, len(sess.data))
+		for k, v := range sess.data {
+			data[k] = v
+		}
+		sess.mu.Unlock()
+
+		entries = append(entries, snapshotEntry{Token: token, Data: data})
+		return true
+	})
+	return entries
+}
+
+// Restore replaces the Manager's live sessions with the ones decoded
+// from r (as written by Snapshot). Each restored session starts with a
+// fresh lastAccess, so IdleTTL eviction measures idle time from the
+// restore rather than whatever was true when the snapshot was taken.
+func (m *Manager) Restore(r io.Reader) error {
+	var entries []snapshotEntry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return fmt.Errorf("session: decoding snapshot: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for token := range m.lruIndex {
+		m.removeLocked(token)
+	}
+
+	for _, entry := range entries {
+		data := entry.Data
+		if data == nil {
+			data = make(map[string]interface{})
+		}
+
+		sess := &Session{data: data, lastAccess: time.Now()}
+		sess.attach(m.store, entry.Token)
+		m.store.Save(entry.Token, sess)
+		m.lruIndex[entry.Token] = m.lru.PushFront(&lruEntry{token: entry.Token, session: sess})
+	}
+
+	return nil
+}
+
+// Close stops the background reaper goroutine, if one was started. It is
+// safe to call on a Manager created without a reaper. In-flight Execute
+// calls are unaffected; Close only stops future reaping.
+func (m *Manager) Close() {
+	if m.stopReap == nil {
+		return
+	}
+	close(m.stopReap)
+	<-m.reapDone
 }
 
 // Session methods
 
-// Execute runs a function within the session's lock
+// Execute runs a function within the session's lock, then persists the
+// session (see persist) since fn may have mutated it.
 func (s *Session) Execute(fn func()) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	fn()
+	func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.lastAccess = time.Now()
+		fn()
+	}()
+	s.persist()
+}
+
+// ExecuteWithData runs fn within the session's lock, passing it the
+// session's live data map so fn can read and mutate it in place without
+// the separate lock/copy round trips SetData/GetData would require for
+// each field. It exists for callers (the script engine's session-aware
+// match rules) that need to check several fields and then write several
+// more as a single atomic step. The session is persisted (see persist)
+// afterwards, since fn may have mutated data.
+func (s *Session) ExecuteWithData(fn func(data map[string]interface{})) {
+	func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.lastAccess = time.Now()
+		fn(s.data)
+	}()
+	s.persist()
 }
 
-// SetData stores data in the session
+// SetData stores data in the session and persists it (see persist).
 func (s *Session) SetData(key string, value interface{}) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.data[key] = value
+	func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.data[key] = value
+	}()
+	s.persist()
 }
 
 // GetData retrieves data from the session
@@ -88,9 +390,19 @@ func (s *Session) GetData(key string) interface{} {
 	return s.data[key]
 }
 
-// Clear removes all data from the session
+// Clear removes all data from the session and persists it (see persist).
 func (s *Session) Clear() {
+	func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.data = make(map[string]interface{})
+	}()
+	s.persist()
+}
+
+// LastAccess returns the time of the session's most recent access.
+func (s *Session) LastAccess() time.Time {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.data = make(map[string]interface{})
-}
\ No newline at end of file
+	return s.lastAccess
+}
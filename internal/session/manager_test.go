@@ -1,6 +1,7 @@
 package session
 
 import (
+	"bytes"
 	"fmt"
 	"sync"
 	"testing"
@@ -235,6 +236,212 @@ func TestRaceConditions(t *testing.T) {
 			manager.ResetSession(token)
 		}(i)
 	}
-	
+
+	wg.Wait()
+}
+
+// TestRaceConditionsWithReaper interleaves session creation/reset with a live
+// background reaper to catch races between request-path access and janitor
+// eviction. Run with -race.
+func TestRaceConditionsWithReaper(t *testing.T) {
+	manager := NewManagerWithOptions(ManagerOptions{
+		IdleTTL:      5 * time.Millisecond,
+		ReapInterval: time.Millisecond,
+	})
+	defer manager.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			token := fmt.Sprintf("token-%d", id%10)
+			session := manager.GetOrCreateSession(token)
+			session.SetData("test", id)
+		}(i)
+	}
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			token := fmt.Sprintf("token-%d", id%10)
+			manager.ResetSession(token)
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+// TestIdleTTLEviction verifies the background reaper evicts sessions that
+// have gone untouched for longer than IdleTTL.
+func TestIdleTTLEviction(t *testing.T) {
+	manager := NewManagerWithOptions(ManagerOptions{
+		IdleTTL:      20 * time.Millisecond,
+		ReapInterval: 5 * time.Millisecond,
+	})
+	defer manager.Close()
+
+	token := "idle-token"
+	manager.GetOrCreateSession(token)
+
+	if manager.GetSession(token) == nil {
+		t.Fatal("session should exist immediately after creation")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if manager.GetSession(token) != nil {
+		t.Error("session should have been reaped after exceeding IdleTTL")
+	}
+}
+
+// TestIdleTTLResetByAccess verifies that accessing a session resets its idle
+// clock so an active session survives past its original TTL deadline.
+func TestIdleTTLResetByAccess(t *testing.T) {
+	manager := NewManagerWithOptions(ManagerOptions{
+		IdleTTL:      30 * time.Millisecond,
+		ReapInterval: 5 * time.Millisecond,
+	})
+	defer manager.Close()
+
+	token := "kept-alive"
+	session := manager.GetOrCreateSession(token)
+
+	deadline := time.Now().Add(80 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		session.Execute(func() {})
+	}
+
+	if manager.GetSession(token) == nil {
+		t.Error("repeatedly-accessed session should not be reaped")
+	}
+}
+
+// TestLRUEviction verifies that once MaxSessions is reached, creating a new
+// session evicts the least-recently-used one.
+func TestLRUEviction(t *testing.T) {
+	manager := NewManagerWithOptions(ManagerOptions{MaxSessions: 2})
+
+	manager.GetOrCreateSession("a")
+	manager.GetOrCreateSession("b")
+
+	// Touch "a" so "b" becomes the least-recently-used entry.
+	manager.GetOrCreateSession("a")
+
+	manager.GetOrCreateSession("c")
+
+	if manager.GetSession("b") != nil {
+		t.Error("expected least-recently-used session 'b' to be evicted")
+	}
+	if manager.GetSession("a") == nil {
+		t.Error("recently-used session 'a' should survive eviction")
+	}
+	if manager.GetSession("c") == nil {
+		t.Error("newly created session 'c' should exist")
+	}
+}
+
+// TestManagerCloseStopsReaper verifies Close shuts down the reaper goroutine
+// and that a subsequent idle session is no longer evicted.
+func TestManagerCloseStopsReaper(t *testing.T) {
+	manager := NewManagerWithOptions(ManagerOptions{
+		IdleTTL:      5 * time.Millisecond,
+		ReapInterval: 5 * time.Millisecond,
+	})
+
+	manager.Close()
+
+	token := "post-close"
+	manager.GetOrCreateSession(token)
+
+	time.Sleep(30 * time.Millisecond)
+
+	if manager.GetSession(token) == nil {
+		t.Error("session should survive once the reaper has been stopped")
+	}
+}
+
+// TestSnapshotRestoreRoundTrip verifies a session's data map survives a
+// Snapshot into a fresh Manager via Restore.
+func TestSnapshotRestoreRoundTrip(t *testing.T) {
+	src := NewManager()
+	session := src.GetOrCreateSession("alice")
+	session.SetData("script", map[string]interface{}{"reset": true})
+	session.SetData("count", float64(3))
+
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	dst := NewManager()
+	if err := dst.Restore(&buf); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	restored := dst.GetSession("alice")
+	if restored == nil {
+		t.Fatal("expected session 'alice' to exist after Restore")
+	}
+	if restored.GetData("count") != float64(3) {
+		t.Errorf("count = %v, want 3", restored.GetData("count"))
+	}
+	script, ok := restored.GetData("script").(map[string]interface{})
+	if !ok || script["reset"] != true {
+		t.Errorf("script = %v, want {reset: true}", restored.GetData("script"))
+	}
+}
+
+// TestRestoreReplacesExistingSessions verifies Restore discards whatever
+// sessions a Manager already had rather than merging with them.
+func TestRestoreReplacesExistingSessions(t *testing.T) {
+	manager := NewManager()
+	manager.GetOrCreateSession("stale")
+
+	var buf bytes.Buffer
+	if err := NewManager().Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	if err := manager.Restore(&buf); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	if manager.GetSession("stale") != nil {
+		t.Error("expected Restore to discard sessions absent from the snapshot")
+	}
+}
+
+// TestSnapshotSafeDuringConcurrentAccess verifies Snapshot doesn't race
+// or deadlock against concurrent GetOrCreateSession/SetData traffic.
+func TestSnapshotSafeDuringConcurrentAccess(t *testing.T) {
+	manager := NewManager()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				session := manager.GetOrCreateSession("live")
+				session.SetData("i", i)
+			}
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		var buf bytes.Buffer
+		if err := manager.Snapshot(&buf); err != nil {
+			t.Fatalf("Snapshot: %v", err)
+		}
+	}
+
+	close(stop)
 	wg.Wait()
 }
\ No newline at end of file
@@ -0,0 +1,69 @@
+package session
+
+import "sync"
+
+// SessionStore is the persistence backend behind Manager. It stores and
+// retrieves sessions by opaque bearer token and must be safe for concurrent
+// use by multiple goroutines (and, for shared backends like RedisStore, by
+// multiple replicas of the emulator).
+type SessionStore interface {
+	// Load returns the session stored under token, if any.
+	Load(token string) (*Session, bool)
+	// Save persists session under token, creating or overwriting any
+	// existing entry.
+	Save(token string, session *Session)
+	// Delete removes the session stored under token. It is a no-op if no
+	// such session exists.
+	Delete(token string)
+	// Range calls fn once for every stored session, stopping early if fn
+	// returns false. Iteration order is unspecified.
+	Range(fn func(token string, session *Session) bool)
+}
+
+// InMemoryStore is the default SessionStore, backed by a plain map. It is
+// what Manager used internally before SessionStore was extracted.
+type InMemoryStore struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+}
+
+// NewInMemoryStore creates an empty in-memory session store.
+func NewInMemoryStore() *InMemoryStore {
+	return &InMemoryStore{
+		sessions: make(map[string]*Session),
+	}
+}
+
+func (s *InMemoryStore) Load(token string) (*Session, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	session, ok := s.sessions[token]
+	return session, ok
+}
+
+func (s *InMemoryStore) Save(token string, session *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[token] = session
+}
+
+func (s *InMemoryStore) Delete(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+}
+
+func (s *InMemoryStore) Range(fn func(token string, session *Session) bool) {
+	s.mu.RLock()
+	snapshot := make(map[string]*Session, len(s.sessions))
+	for token, session := range s.sessions {
+		snapshot[token] = session
+	}
+	s.mu.RUnlock()
+
+	for token, session := range snapshot {
+		if !fn(token, session) {
+			return
+		}
+	}
+}
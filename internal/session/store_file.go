@@ -0,0 +1,142 @@
+package session
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// persistedSession is the on-disk/wire representation of a Session, used by
+// FileStore and RedisStore. A Session's mutex is never serialized; it is
+// reconstructed fresh on Load.
+type persistedSession struct {
+	Data       map[string]interface{} `json:"data"`
+	LastAccess time.Time              `json:"last_access"`
+}
+
+// FileStore persists each session as one JSON file per token under Dir,
+// so scripted scenarios survive an emulator restart. Writes are atomic:
+// Save writes to a temp file and renames it into place.
+type FileStore struct {
+	dir string
+
+	// mu serializes writes from this process; it does not protect against
+	// concurrent writers in other processes sharing Dir, which is why Save
+	// still writes-then-renames rather than editing in place.
+	mu sync.Mutex
+}
+
+// NewFileStore creates a FileStore rooted at dir, creating it if necessary.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("session: create store dir: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (f *FileStore) path(token string) string {
+	return filepath.Join(f.dir, tokenFilename(token)+".json")
+}
+
+func (f *FileStore) Load(token string) (*Session, bool) {
+	raw, err := os.ReadFile(f.path(token))
+	if err != nil {
+		return nil, false
+	}
+
+	var persisted persistedSession
+	if err := json.Unmarshal(raw, &persisted); err != nil {
+		return nil, false
+	}
+
+	if persisted.Data == nil {
+		persisted.Data = make(map[string]interface{})
+	}
+
+	return &Session{
+		data:       persisted.Data,
+		lastAccess: persisted.LastAccess,
+	}, true
+}
+
+func (f *FileStore) Save(token string, session *Session) {
+	session.mu.Lock()
+	persisted := persistedSession{
+		Data:       session.data,
+		LastAccess: session.lastAccess,
+	}
+	session.mu.Unlock()
+
+	raw, err := json.Marshal(persisted)
+	if err != nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tmp, err := os.CreateTemp(f.dir, tokenFilename(token)+".*.tmp")
+	if err != nil {
+		return
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return
+	}
+
+	os.Rename(tmpPath, f.path(token))
+}
+
+func (f *FileStore) Delete(token string) {
+	os.Remove(f.path(token))
+}
+
+func (f *FileStore) Range(fn func(token string, session *Session) bool) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".json" {
+			continue
+		}
+
+		token := tokenFromFilename(name[:len(name)-len(".json")])
+		session, ok := f.Load(token)
+		if !ok {
+			continue
+		}
+		if !fn(token, session) {
+			return
+		}
+	}
+}
+
+// tokenFilename escapes a bearer token into a safe filename component. Bearer
+// tokens are opaque strings that may contain path-hostile characters, so we
+// hex-encode them rather than writing them to disk verbatim.
+func tokenFilename(token string) string {
+	return hex.EncodeToString([]byte(token))
+}
+
+// tokenFromFilename reverses tokenFilename.
+func tokenFromFilename(name string) string {
+	decoded, err := hex.DecodeString(name)
+	if err != nil {
+		return ""
+	}
+	return string(decoded)
+}
@@ -0,0 +1,112 @@
+//go:build redis
+
+package session
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisKeyPrefix namespaces every key this store touches so it can safely
+// share a Redis instance with other tenants.
+const redisKeyPrefix = "llemu:sess:"
+
+// RedisStore persists sessions in Redis so multiple emulator replicas behind
+// a load balancer see consistent scripted state for a given bearer token.
+// Build with `-tags redis` to include it; it is excluded by default so the
+// rest of the module doesn't pick up a hard dependency on go-redis.
+type RedisStore struct {
+	client *redis.Client
+	ctx    context.Context
+}
+
+// NewRedisStore creates a RedisStore using an already-configured client.
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client, ctx: context.Background()}
+}
+
+func redisKey(token string) string {
+	return redisKeyPrefix + token
+}
+
+func (r *RedisStore) Load(token string) (*Session, bool) {
+	raw, err := r.client.Get(r.ctx, redisKey(token)).Bytes()
+	if err != nil {
+		return nil, false
+	}
+
+	var persisted persistedSession
+	if err := json.Unmarshal(raw, &persisted); err != nil {
+		return nil, false
+	}
+	if persisted.Data == nil {
+		persisted.Data = make(map[string]interface{})
+	}
+
+	return &Session{
+		data:       persisted.Data,
+		lastAccess: persisted.LastAccess,
+	}, true
+}
+
+// Save writes session to Redis with a SET inside a WATCH/MULTI transaction
+// on the token's key. If the key changes between WATCH and EXEC, it retries
+// the same value, up to 5 attempts. It does not read or merge the stored
+// value, so when two replicas save the same token concurrently the later
+// write replaces the earlier one.
+func (r *RedisStore) Save(token string, session *Session) {
+	session.mu.Lock()
+	persisted := persistedSession{
+		Data:       session.data,
+		LastAccess: session.lastAccess,
+	}
+	session.mu.Unlock()
+
+	raw, err := json.Marshal(persisted)
+	if err != nil {
+		return
+	}
+
+	key := redisKey(token)
+	const maxRetries = 5
+
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		err := r.client.Watch(r.ctx, func(tx *redis.Tx) error {
+			_, err := tx.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(r.ctx, key, raw, 0)
+				return nil
+			})
+			return err
+		}, key)
+
+		if err == nil {
+			return
+		}
+		if err != redis.TxFailedErr {
+			return
+		}
+		// Another writer won the race; retry with our still-current value.
+	}
+}
+
+func (r *RedisStore) Delete(token string) {
+	r.client.Del(r.ctx, redisKey(token))
+}
+
+func (r *RedisStore) Range(fn func(token string, session *Session) bool) {
+	iter := r.client.Scan(r.ctx, 0, redisKeyPrefix+"*", 0).Iterator()
+	for iter.Next(r.ctx) {
+		key := iter.Val()
+		token := key[len(redisKeyPrefix):]
+
+		session, ok := r.Load(token)
+		if !ok {
+			continue
+		}
+		if !fn(token, session) {
+			return
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package session
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestInMemoryStoreBasics exercises Load/Save/Delete/Range directly.
+func TestInMemoryStoreBasics(t *testing.T) {
+	store := NewInMemoryStore()
+
+	if _, ok := store.Load("missing"); ok {
+		t.Error("expected no session for an unknown token")
+	}
+
+	session := &Session{data: map[string]interface{}{"k": "v"}}
+	store.Save("tok", session)
+
+	got, ok := store.Load("tok")
+	if !ok || got != session {
+		t.Error("expected Load to return the saved session")
+	}
+
+	seen := map[string]bool{}
+	store.Range(func(token string, s *Session) bool {
+		seen[token] = true
+		return true
+	})
+	if !seen["tok"] {
+		t.Error("expected Range to visit the saved token")
+	}
+
+	store.Delete("tok")
+	if _, ok := store.Load("tok"); ok {
+		t.Error("expected session to be gone after Delete")
+	}
+}
+
+// TestFileStoreRoundTrip verifies a session saved to disk can be reloaded
+// with its data intact, including after process-boundary-style reload (a
+// fresh FileStore pointed at the same directory).
+func TestFileStoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+
+	token := "Bearer token/with:odd chars"
+	session := &Session{data: map[string]interface{}{"name": "Fish", "count": float64(3)}}
+	store.Save(token, session)
+
+	reloaded, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore (reload) failed: %v", err)
+	}
+
+	got, ok := reloaded.Load(token)
+	if !ok {
+		t.Fatal("expected session to round-trip through disk")
+	}
+	if got.data["name"] != "Fish" || got.data["count"] != float64(3) {
+		t.Errorf("session data did not round-trip, got: %#v", got.data)
+	}
+}
+
+// TestFileStoreDeleteAndRange verifies Delete removes the on-disk file and
+// Range only visits what remains.
+func TestFileStoreDeleteAndRange(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+
+	store.Save("keep", &Session{data: map[string]interface{}{}})
+	store.Save("drop", &Session{data: map[string]interface{}{}})
+	store.Delete("drop")
+
+	seen := map[string]bool{}
+	store.Range(func(token string, s *Session) bool {
+		seen[token] = true
+		return true
+	})
+
+	if seen["drop"] {
+		t.Error("deleted token should not appear in Range")
+	}
+	if !seen["keep"] {
+		t.Error("expected remaining token to appear in Range")
+	}
+}
+
+// TestFileStoreAtomicSave verifies Save doesn't leave temp files behind on
+// success.
+func TestFileStoreAtomicSave(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+
+	store.Save("tok", &Session{data: map[string]interface{}{"x": 1.0}})
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if len(matches) != 0 {
+		t.Errorf("expected no leftover temp files after Save, found: %v", matches)
+	}
+}
+
+// TestManagerWithFileStore verifies Manager works unchanged when backed by
+// a FileStore instead of the default InMemoryStore, and that a mutation
+// made through the Session it returns is visible to a fresh Load rather
+// than only being persisted at creation time.
+func TestManagerWithFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+
+	manager := NewManagerWithStore(store, ManagerOptions{})
+
+	session := manager.GetOrCreateSession("tok")
+	session.SetData("key", "value")
+
+	reloaded, ok := store.Load("tok")
+	if !ok || reloaded.GetData("key") != "value" {
+		t.Error("expected session data to persist through the backing FileStore")
+	}
+}
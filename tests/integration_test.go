@@ -441,6 +441,128 @@ func TestExplicitTwoTokenIsolation(t *testing.T) {
 	}
 }
 
+// TestSnapshotRestoreMidTwoTokenSequence runs the same animal/color
+// sequence TestExplicitTwoTokenIsolation does partway through, snapshots
+// via GET /_emulator/snapshot, discards the server entirely, restores the
+// snapshot into a fresh one via POST /_emulator/restore, and verifies the
+// remaining steps of both tokens' sequences still play out correctly —
+// the same round trip a test suite uses to freeze and resume emulator
+// state between phases.
+func TestSnapshotRestoreMidTwoTokenSequence(t *testing.T) {
+	srv := server.NewServer()
+	ts := httptest.NewServer(srv)
+
+	tokenAnimals := "snapshot-token-animals"
+	tokenColors := "snapshot-token-colors"
+
+	loadSequentialScript := func(token string, responses []string) {
+		scriptBody, _ := json.Marshal(map[string]interface{}{"reset": true, "responses": responses})
+		scriptReq, _ := http.NewRequest("POST", ts.URL+"/_emulator/script", bytes.NewReader(scriptBody))
+		scriptReq.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(scriptReq)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("failed to load script for %s: %v", token, err)
+		}
+		resp.Body.Close()
+	}
+
+	loadSequentialScript(tokenAnimals, []string{"Dog", "Cat", "Bird", "Mouse", "Fish"})
+	loadSequentialScript(tokenColors, []string{"Red", "Blue", "Green", "Yellow", "Purple"})
+
+	makeRequest := func(client *http.Client, baseURL, token, message string) (string, error) {
+		chatBody, _ := json.Marshal(map[string]interface{}{
+			"model":    "gpt-4",
+			"messages": []map[string]interface{}{{"role": "user", "content": message}},
+		})
+		chatReq, _ := http.NewRequest("POST", baseURL+"/v1/chat/completions", bytes.NewReader(chatBody))
+		chatReq.Header.Set("Authorization", "Bearer "+token)
+		resp, err := client.Do(chatReq)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		var result map[string]interface{}
+		if err := json.Unmarshal(body, &result); err != nil {
+			return "", err
+		}
+		if errObj, ok := result["error"].(map[string]interface{}); ok {
+			return "", fmt.Errorf("%v", errObj["message"])
+		}
+		choices, _ := result["choices"].([]interface{})
+		if len(choices) == 0 {
+			return "", fmt.Errorf("no choices in response")
+		}
+		message2 := choices[0].(map[string]interface{})["message"].(map[string]interface{})
+		return message2["content"].(string), nil
+	}
+
+	// Walk both sequences partway through, through "Bird"/"Green".
+	midSteps := []struct {
+		token    string
+		expected string
+	}{
+		{tokenAnimals, "Dog"},
+		{tokenColors, "Red"},
+		{tokenColors, "Blue"},
+		{tokenColors, "Green"},
+		{tokenAnimals, "Cat"},
+		{tokenAnimals, "Bird"},
+	}
+	for _, step := range midSteps {
+		got, err := makeRequest(http.DefaultClient, ts.URL, step.token, "advance")
+		if err != nil {
+			t.Fatalf("mid-sequence request for %s failed: %v", step.token, err)
+		}
+		if got != step.expected {
+			t.Fatalf("mid-sequence request for %s: got %q, want %q", step.token, got, step.expected)
+		}
+	}
+
+	snapResp, err := http.Get(ts.URL + "/_emulator/snapshot")
+	if err != nil || snapResp.StatusCode != http.StatusOK {
+		t.Fatalf("snapshot request failed: %v", err)
+	}
+	snapshot, _ := io.ReadAll(snapResp.Body)
+	snapResp.Body.Close()
+	ts.Close()
+
+	// Stand up a brand new server with no knowledge of either token, and
+	// restore the snapshot into it.
+	restoredSrv := server.NewServer()
+	restoredTS := httptest.NewServer(restoredSrv)
+	defer restoredTS.Close()
+
+	restoreReq, _ := http.NewRequest("POST", restoredTS.URL+"/_emulator/restore", bytes.NewReader(snapshot))
+	restoreResp, err := http.DefaultClient.Do(restoreReq)
+	if err != nil || restoreResp.StatusCode != http.StatusOK {
+		t.Fatalf("restore request failed: %v", err)
+	}
+	restoreResp.Body.Close()
+
+	// The remaining sequential steps should pick up exactly where the
+	// original server left off.
+	remainingSteps := []struct {
+		token    string
+		expected string
+	}{
+		{tokenAnimals, "Mouse"},
+		{tokenAnimals, "Fish"},
+		{tokenColors, "Yellow"},
+		{tokenColors, "Purple"},
+	}
+	for _, step := range remainingSteps {
+		got, err := makeRequest(http.DefaultClient, restoredTS.URL, step.token, "continue")
+		if err != nil {
+			t.Fatalf("post-restore request for %s failed: %v", step.token, err)
+		}
+		if got != step.expected {
+			t.Errorf("post-restore request for %s: got %q, want %q", step.token, got, step.expected)
+		}
+	}
+}
+
 // TestRequestSerialization tests that requests for same token serialize
 func TestRequestSerialization(t *testing.T) {
 	srv := server.NewServer()